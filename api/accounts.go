@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AccountRequest opens account holding the currencies
+type AccountRequest struct {
+	Currencies []string `json:"currencies" binding:"required,min=1,max=20"`
+}
+
+// CurrencyRequest adds currency to account
+type CurrencyRequest struct {
+	Currency string `json:"currency" binding:"required"`
+}
+
+// parseCurrencies returns distinct supported currencies in the order given
+func parseCurrencies(codes []string) ([]models.Currency, error) {
+	currencies := make([]models.Currency, 0, len(codes))
+	seen := make(map[models.Currency]bool, len(codes))
+	for _, code := range codes {
+		c, err := models.ParseCurrency(code)
+		if err != nil {
+			return nil, apperrors.NewValidation("request", errors.Errorf("Currency %q is not supported", code))
+		}
+		if !seen[c] {
+			seen[c] = true
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies, nil
+}
+
+// ----------------------------------
+
+type accountsService interface {
+	Create(ctx context.Context, currencies []models.Currency) (int, error)
+	AddCurrency(ctx context.Context, w models.Wallet) error
+}
+
+type accountsResource struct {
+	svc  accountsService
+	resp SimpleResponder
+}
+
+// NewAccountsResource returns Accounts admin API resource
+func NewAccountsResource(svc accountsService, resp SimpleResponder) *accountsResource {
+	return &accountsResource{
+		svc:  svc,
+		resp: resp,
+	}
+}
+
+// CreateAccount opens account with zero balances
+func (r *accountsResource) CreateAccount(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	currencies, err := parseCurrencies(req.Currencies)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	id, err := r.svc.Create(c.Request.Context(), currencies)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.Created(c, newAccountView(id, currencies))
+}
+
+// AddCurrency opens zero balance of the account in one more currency
+func (r *accountsResource) AddCurrency(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	var req CurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	currencies, err := parseCurrencies([]string{req.Currency})
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	err = r.svc.AddCurrency(c.Request.Context(), models.Wallet{AccountID: id, Currency: currencies[0]})
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.Created(c, newAccountView(id, currencies))
+}
+
+func newAccountView(id int, currencies []models.Currency) gin.H {
+	return gin.H{
+		"accountId":  id,
+		"currencies": currencies,
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/middleware"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+type stubAccounts struct {
+	wallets []map[models.Currency]bool
+}
+
+func (s *stubAccounts) Create(_ context.Context, currencies []models.Currency) (int, error) {
+	held := make(map[models.Currency]bool, len(currencies))
+	for _, c := range currencies {
+		held[c] = true
+	}
+	s.wallets = append(s.wallets, held)
+	return len(s.wallets), nil
+}
+
+func (s *stubAccounts) AddCurrency(_ context.Context, w models.Wallet) error {
+	if w.AccountID < 1 || w.AccountID > len(s.wallets) {
+		return apperrors.NewNotFound(errors.New("Account not found"))
+	}
+	held := s.wallets[w.AccountID-1]
+	if held[w.Currency] {
+		return apperrors.NewConflict(errors.New("Account already holds this currency"), nil)
+	}
+	held[w.Currency] = true
+	return nil
+}
+
+// Accounts are opened and given currencies through admin API
+func TestAccountsResource(t *testing.T) {
+	a := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	responder := NewResponder()
+	res := NewAccountsResource(&stubAccounts{}, responder)
+	r := gin.New()
+	r.Use(middleware.ErrorHandler(responder))
+	r.POST("/admin/accounts", res.CreateAccount)
+	r.POST("/admin/accounts/:id/currencies", res.AddCurrency)
+
+	post := func(path, body string) (int, []string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var resp struct {
+			Data struct {
+				Item struct {
+					AccountID  int      `json:"accountId"`
+					Currencies []string `json:"currencies"`
+				} `json:"item"`
+			} `json:"data"`
+		}
+		a.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Data.Item.Currencies
+	}
+
+	code, currencies := post("/admin/accounts", `{"currencies": ["usd", "EUR", "USD"]}`)
+	a.Equal(http.StatusCreated, code)
+	a.Equal([]string{"USD", "EUR"}, currencies)
+
+	code, currencies = post("/admin/accounts/1/currencies", `{"currency": "jpy"}`)
+	a.Equal(http.StatusCreated, code)
+	a.Equal([]string{"JPY"}, currencies)
+
+	code, _ = post("/admin/accounts/1/currencies", `{"currency": "EUR"}`)
+	a.Equal(http.StatusConflict, code)
+	code, _ = post("/admin/accounts/2/currencies", `{"currency": "EUR"}`)
+	a.Equal(http.StatusNotFound, code)
+	code, _ = post("/admin/accounts", `{"currencies": ["XXX"]}`)
+	a.Equal(http.StatusUnprocessableEntity, code)
+	code, _ = post("/admin/accounts", `{"currencies": []}`)
+	a.Equal(http.StatusUnprocessableEntity, code)
+}
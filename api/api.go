@@ -11,7 +11,9 @@ import (
 
 type SimpleResponder interface {
 	OK(c *gin.Context, res interface{})
+	List(c *gin.Context, res interface{}, pagination *Pagination)
 	Created(c *gin.Context, res interface{})
+	NotModified(c *gin.Context)
 	NotFound(c *gin.Context, err error)
 	BadRequest(c *gin.Context, description string, err error)
 }
@@ -40,14 +42,27 @@ func (r *Responder) OK(c *gin.Context, res interface{}) {
 	response(c, http.StatusOK, 0, res, nil)
 }
 
+func (r *Responder) List(c *gin.Context, res interface{}, pagination *Pagination) {
+	response(c, http.StatusOK, 0, res, pagination)
+}
+
 func (r *Responder) Created(c *gin.Context, res interface{}) {
 	response(c, http.StatusCreated, 0, res, nil)
 }
 
+func (r *Responder) NotModified(c *gin.Context) {
+	c.Status(http.StatusNotModified)
+	c.Abort()
+}
+
 func (r *Responder) BadRequest(c *gin.Context, description string, err error) {
 	responseErr(c, http.StatusBadRequest, description, err, nil)
 }
 
+func (r *Responder) Unauthorized(c *gin.Context, err error) {
+	responseErr(c, http.StatusUnauthorized, "", err, nil)
+}
+
 func (r *Responder) NotFound(c *gin.Context, err error) {
 	responseErr(c, http.StatusNotFound, "", err, nil)
 }
@@ -60,6 +75,14 @@ func (r *Responder) Unprocessable(c *gin.Context, description string, err error)
 	responseErr(c, http.StatusUnprocessableEntity, description, err, nil)
 }
 
+func (r *Responder) Conflict(c *gin.Context, fields []string) {
+	responseErr(c, http.StatusConflict, "", nil, fields)
+}
+
+func (r *Responder) Timeout(c *gin.Context, err error) {
+	responseErr(c, http.StatusGatewayTimeout, "Request timed out", err, nil)
+}
+
 func (r *Responder) InternalError(c *gin.Context, err error) {
 	responseErr(c, http.StatusInternalServerError, "", err, nil)
 }
@@ -121,7 +144,8 @@ func GetCorsConfig() cors.Config {
 	return cors.Config{
 		AllowAllOrigins:  true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Length", "Content-Type", "Source-Type"},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Length", "Content-Type", "Source-Type", "Source-Token", "If-None-Match", "Last-Event-ID"},
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
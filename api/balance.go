@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// BalanceQuery selects balances of the account
+type BalanceQuery struct {
+	AccountID int    `form:"accountId" binding:"required,min=1"`
+	Currency  string `form:"currency" binding:"omitempty,len=3"`
+}
+
+// BalanceView is wallet balance representation in responses
+type BalanceView struct {
+	Currency  models.Currency `json:"currency"`
+	Total     models.Money    `json:"total"`
+	Version   int64           `json:"version"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// ----------------------------------
+
+type balanceService interface {
+	Get(ctx context.Context, accountID int) ([]models.Balance, error)
+}
+
+type balanceResource struct {
+	svc  balanceService
+	resp SimpleResponder
+}
+
+// NewBalanceResource returns Balance API resource
+func NewBalanceResource(svc balanceService, resp SimpleResponder) *balanceResource {
+	return &balanceResource{
+		svc:  svc,
+		resp: resp,
+	}
+}
+
+// GetBalance returns account balances, or 304 if client already has their current version
+func (r *balanceResource) GetBalance(c *gin.Context) {
+	var q BalanceQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	balances, err := r.svc.Get(c.Request.Context(), q.AccountID)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]BalanceView, 0, len(balances))
+	for _, b := range balances {
+		if q.Currency != "" && !strings.EqualFold(string(b.Currency), q.Currency) {
+			continue
+		}
+		views = append(views, BalanceView{
+			Currency:  b.Currency,
+			Total:     b.Total,
+			Version:   b.Version,
+			UpdatedAt: b.UpdatedAt,
+		})
+	}
+	if len(views) == 0 {
+		c.Error(apperrors.NewNotFound(errors.New("Account does not hold this currency")))
+		return
+	}
+
+	etag := balanceETag(q.AccountID, views)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		r.resp.NotModified(c)
+		return
+	}
+	r.resp.OK(c, gin.H{
+		"accountId": q.AccountID,
+		"balances":  views,
+	})
+}
+
+// balanceETag changes whenever any of the balances changes
+func balanceETag(accountID int, views []BalanceView) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d", accountID)
+	for _, v := range views {
+		fmt.Fprintf(h, ";%s:%d", v.Currency, v.Version)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// etagMatches checks If-None-Match header value against the current ETag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/middleware"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+const (
+	// maxBatchEvents limits events of one batch
+	maxBatchEvents = 1000
+	// maxBatchBytes limits body of one batch, an event takes up to about 5KB with metadata
+	maxBatchBytes = 8 << 20
+
+	batchAtomic      = "atomic"
+	batchIndependent = "independent"
+)
+
+// BatchQuery chooses how events of a batch are applied
+type BatchQuery struct {
+	// Mode is "independent" (default) applying every event on its own,
+	// or "atomic" storing nothing unless every event is created or duplicate
+	Mode string `form:"mode" binding:"omitempty,oneof=atomic independent"`
+}
+
+// BatchItemView is outcome of one event of a batch
+type BatchItemView struct {
+	// Index is position of the event in the batch, from 0
+	Index         int                `json:"index"`
+	TransactionID string             `json:"transactionId,omitempty"`
+	Status        models.BatchStatus `json:"status"`
+	Errors        []string           `json:"errors,omitempty"`
+}
+
+// BatchView is outcome of a batch
+type BatchView struct {
+	Mode string `json:"mode"`
+	// Committed is false for atomic batch which stored nothing
+	Committed bool `json:"committed"`
+	// Summary counts events by status
+	Summary map[models.BatchStatus]int `json:"summary"`
+	Results []BatchItemView            `json:"results"`
+}
+
+// decodeBatch reads events from JSON array or from stream of JSON values like NDJSON
+func decodeBatch(body io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, apperrors.NewBadRequest(errors.New("Batch is empty"))
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	dec := json.NewDecoder(br)
+	var items []json.RawMessage
+	if first == '[' {
+		if err := dec.Decode(&items); err != nil {
+			return nil, apperrors.NewBadRequest(errors.Wrap(err, "Batch is not a valid JSON array"))
+		}
+	} else {
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, apperrors.NewBadRequest(errors.Wrapf(err, "Event %d is not valid JSON", len(items)))
+			}
+			items = append(items, raw)
+			if len(items) > maxBatchEvents {
+				break
+			}
+		}
+	}
+	if len(items) == 0 {
+		return nil, apperrors.NewBadRequest(errors.New("Batch is empty"))
+	}
+	if len(items) > maxBatchEvents {
+		return nil, apperrors.NewBadRequest(errors.Errorf("Batch cannot have more than %d events", maxBatchEvents))
+	}
+	return items, nil
+}
+
+// peekNonSpace skips leading whitespace and returns the next byte without consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0], nil
+		}
+		if _, err := r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// parseBatchEvent turns raw event into model, transaction ID is returned when it's readable
+func parseBatchEvent(raw json.RawMessage) (models.Event, string, error) {
+	var req StateResultEvent
+	if err := json.Unmarshal(raw, &req); err != nil {
+		// type error of the whole value means it isn't an object
+		if te, ok := err.(*json.UnmarshalTypeError); ok && te.Field != "" {
+			return models.Event{}, req.TransactionID, err
+		}
+		return models.Event{}, "", apperrors.NewValidation("request", errors.New("Event must be a JSON object"))
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return models.Event{}, req.TransactionID, err
+	}
+	e, err := req.validateToModel()
+	return e, req.TransactionID, err
+}
+
+// ProcessBatch creates events sent at once, like rounds settled by a game server
+func (r *eventsResource) ProcessBatch(c *gin.Context) {
+	var q BatchQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	if q.Mode == "" {
+		q.Mode = batchIndependent
+	}
+	atomic := q.Mode == batchAtomic
+	items, err := decodeBatch(http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes))
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	sourceType := strings.ToLower(c.GetHeader(middleware.SourceTypeHeader))
+	views := make([]BatchItemView, len(items))
+	results := make([]models.BatchResult, len(items))
+	events := make([]models.Event, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	for i, raw := range items {
+		e, transactionID, err := parseBatchEvent(raw)
+		views[i] = BatchItemView{Index: i, TransactionID: transactionID}
+		if err != nil {
+			results[i] = models.BatchResult{Status: models.BatchInvalid, Err: err}
+			continue
+		}
+		e.SourceType = sourceType
+		events = append(events, e)
+		indexes = append(indexes, i)
+	}
+	if atomic && models.BatchFailed(results) {
+		models.AbortBatch(results)
+	} else {
+		created, err := r.svc.CreateBatch(c.Request.Context(), events, atomic)
+		if err != nil {
+			c.Error(errors.WithStack(err))
+			return
+		}
+		models.MergeBatch(results, indexes, created)
+	}
+	res := BatchView{
+		Mode:      q.Mode,
+		Committed: !atomic || !models.BatchFailed(results),
+		Summary:   make(map[models.BatchStatus]int),
+		Results:   views,
+	}
+	for i, result := range results {
+		res.Summary[result.Status]++
+		res.Results[i].Status = result.Status
+		if result.Err != nil {
+			res.Results[i].Errors = middleware.ErrorMessages(result.Err)
+		}
+	}
+	r.resp.OK(c, res)
+}
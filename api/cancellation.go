@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/cancellation"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CancellationQuery selects wallets to preview and overrides configured strategy
+type CancellationQuery struct {
+	AccountID  int    `form:"accountId" binding:"omitempty,min=1"`
+	Strategy   string `form:"strategy" binding:"omitempty,oneof=odd-rows last older-than source-type state"`
+	Number     int    `form:"number" binding:"omitempty,min=1"`
+	OlderThan  string `form:"olderThan"`
+	SourceType string `form:"sourceType"`
+	State      string `form:"state" binding:"omitempty,oneof=win loss"`
+	OrderBy    string `form:"orderBy" binding:"omitempty,oneof=ingestion occurrence"`
+	// Partial overrides configured partial mode
+	Partial *bool `form:"partial"`
+}
+
+// CancellationResultView is cancellation result of one wallet in responses
+type CancellationResultView struct {
+	AccountID     int             `json:"accountId"`
+	Currency      models.Currency `json:"currency"`
+	EventIDs      []int           `json:"eventIds"`
+	BalanceBefore models.Money    `json:"balanceBefore"`
+	BalanceAfter  models.Money    `json:"balanceAfter"`
+	SkippedIDs    []int           `json:"skippedEventIds,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// CancellationRunsQuery selects page of cancellation runs
+type CancellationRunsQuery struct {
+	Page    int `form:"page" binding:"omitempty,min=1"`
+	PerPage int `form:"perPage" binding:"omitempty,min=1,max=100"`
+}
+
+// CancellationRunView is cancellation run representation in responses
+type CancellationRunView struct {
+	ID         int                      `json:"id"`
+	Strategy   string                   `json:"strategy"`
+	Params     map[string]interface{}   `json:"params"`
+	Partial    bool                     `json:"partial"`
+	StartedAt  time.Time                `json:"startedAt"`
+	FinishedAt *time.Time               `json:"finishedAt"`
+	Error      string                   `json:"error,omitempty"`
+	Wallets    []CancellationResultView `json:"wallets"`
+}
+
+// ----------------------------------
+
+type cancellationService interface {
+	PreviewCancellation(ctx context.Context, strategy cancellation.Strategy, opts cancellation.Options,
+		accountID int) ([]cancellation.Result, error)
+	ListCancellationRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error)
+}
+
+type cancellationResource struct {
+	svc      cancellationService
+	resp     SimpleResponder
+	strategy cancellation.Strategy
+	opts     cancellation.Options
+}
+
+// NewCancellationResource returns Cancellation API resource,
+// strategy and options are used unless request chooses others
+func NewCancellationResource(svc cancellationService, resp SimpleResponder, strategy cancellation.Strategy,
+	opts cancellation.Options) *cancellationResource {
+	return &cancellationResource{
+		svc:      svc,
+		resp:     resp,
+		strategy: strategy,
+		opts:     opts,
+	}
+}
+
+// PreviewCancellation shows which events cancellation run would void and resulting balances
+func (r *cancellationResource) PreviewCancellation(c *gin.Context) {
+	var q CancellationQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	strategy, err := q.toStrategy(r.strategy)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	opts := r.opts
+	if q.Partial != nil {
+		opts.Partial = *q.Partial
+	}
+	results, err := r.svc.PreviewCancellation(c.Request.Context(), strategy, opts, q.AccountID)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]CancellationResultView, 0, len(results))
+	for _, res := range results {
+		views = append(views, newCancellationResultView(res))
+	}
+	r.resp.OK(c, gin.H{
+		"strategy": strategy.Name(),
+		"params":   strategy.Params(),
+		"dryRun":   true,
+		"partial":  opts.Partial,
+		"results":  views,
+	})
+}
+
+// ListRuns returns page of cancellation runs, newest first
+func (r *cancellationResource) ListRuns(c *gin.Context) {
+	var q CancellationRunsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PerPage == 0 {
+		q.PerPage = defaultPerPage
+	}
+	runs, total, err := r.svc.ListCancellationRuns(c.Request.Context(), q.PerPage, (q.Page-1)*q.PerPage)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]CancellationRunView, 0, len(runs))
+	for _, run := range runs {
+		views = append(views, newCancellationRunView(run))
+	}
+	r.resp.List(c, views, &Pagination{
+		Total:       total,
+		PerPage:     q.PerPage,
+		CurrentPage: q.Page,
+	})
+}
+
+func (q CancellationQuery) toStrategy(def cancellation.Strategy) (cancellation.Strategy, error) {
+	if q.Strategy == "" {
+		return def, nil
+	}
+	var olderThan time.Duration
+	if q.OlderThan != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(q.OlderThan); err != nil {
+			return nil, apperrors.NewBadRequest(errors.New("OlderThan is not valid duration"))
+		}
+	}
+	strategy, err := cancellation.New(q.Strategy, cancellation.Params{
+		Number:     q.Number,
+		OlderThan:  olderThan,
+		SourceType: q.SourceType,
+		State:      models.EventState(q.State),
+		OrderBy:    models.EventTime(q.OrderBy),
+	})
+	if err != nil {
+		return nil, apperrors.NewBadRequest(err)
+	}
+	return strategy, nil
+}
+
+func newCancellationResultView(res cancellation.Result) CancellationResultView {
+	v := CancellationResultView{
+		AccountID:     res.Wallet.AccountID,
+		Currency:      res.Wallet.Currency,
+		EventIDs:      res.EventIDs,
+		BalanceBefore: res.BalanceBefore,
+		BalanceAfter:  res.BalanceAfter,
+		SkippedIDs:    res.SkippedIDs,
+	}
+	if v.EventIDs == nil {
+		v.EventIDs = []int{}
+	}
+	if res.Err != nil {
+		v.Error = res.Err.Error()
+	}
+	return v
+}
+
+func newCancellationRunView(run cancellation.Run) CancellationRunView {
+	v := CancellationRunView{
+		ID:        run.ID,
+		Strategy:  run.Strategy,
+		Params:    run.Params,
+		Partial:   run.Partial,
+		StartedAt: run.StartedAt,
+		Wallets:   make([]CancellationResultView, 0, len(run.Results)),
+	}
+	if !run.FinishedAt.IsZero() {
+		finishedAt := run.FinishedAt
+		v.FinishedAt = &finishedAt
+	}
+	if run.Err != nil {
+		v.Error = run.Err.Error()
+	}
+	for _, res := range run.Results {
+		v.Wallets = append(v.Wallets, newCancellationResultView(res))
+	}
+	return v
+}
@@ -2,26 +2,81 @@ package api
 
 import (
 	"context"
-	"strconv"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/middleware"
 	"github.com/djumpen/test-ex-go/models"
 	"github.com/gin-gonic/gin"
 )
 
 type StateResultEvent struct {
+	AccountID     int    `json:"accountId" binding:"required,min=1"`
 	State         string `json:"state" binding:"required,oneof=win loss"`
-	Amount        string `json:"amount" binding:"required"` // TODO: create numstring validator
-	TransactionID string `json:"transactionId" binding:"required"`
+	Amount        string `json:"amount" binding:"required"`
+	Currency      string `json:"currency" binding:"required,len=3"`
+	TransactionID string `json:"transactionId" binding:"required,max=128"`
+	// OccurredAt is optional time the event occurred at, in RFC 3339 format
+	OccurredAt *time.Time `json:"occurredAt"`
+	// Metadata is optional JSON object like {"roundId": "r-1", "device": {"os": "ios"}}
+	Metadata models.Metadata `json:"metadata"`
 }
 
+// EventsQuery is filter of events list
+type EventsQuery struct {
+	AccountID  int       `form:"accountId" binding:"omitempty,min=1"`
+	Currency   string    `form:"currency" binding:"omitempty,len=3"`
+	State      string    `form:"state" binding:"omitempty,oneof=win loss"`
+	Status     string    `form:"status" binding:"omitempty,oneof=processed canceled"`
+	SourceType string    `form:"sourceType"`
+	From       time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Sort       string    `form:"sort" binding:"omitempty,oneof=date -date"`
+	Page       int       `form:"page" binding:"omitempty,min=1"`
+	PerPage    int       `form:"perPage" binding:"omitempty,min=1,max=100"`
+	// OrderBy chooses event time used by from, to and sort
+	OrderBy string `form:"orderBy" binding:"omitempty,oneof=ingestion occurrence"`
+}
+
+// EventView is event representation in responses
+type EventView struct {
+	ID                int             `json:"id"`
+	AccountID         int             `json:"accountId"`
+	State             string          `json:"state"`
+	Amount            models.Money    `json:"amount"`
+	Currency          models.Currency `json:"currency"`
+	TransactionID     string          `json:"transactionId"`
+	Status            string          `json:"status"`
+	SourceType        string          `json:"sourceType"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	OccurredAt        time.Time       `json:"occurredAt"`
+	CancellationRunID *int            `json:"cancellationRunId,omitempty"`
+	Metadata          models.Metadata `json:"metadata,omitempty"`
+}
+
+const defaultPerPage = 20
+
+// maxClockSkew tolerates clocks of sources running ahead
+const maxClockSkew = time.Minute
+
+const (
+	// metadataParamPrefix starts query parameters filtering on metadata, like metadata.device.os=ios
+	metadataParamPrefix = "metadata."
+	// maxMetadataParams limits metadata conditions of one query
+	maxMetadataParams = 5
+)
+
 // ----------------------------------
 
 type eventsService interface {
 	Create(context.Context, models.Event) error
+	CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error)
+	Get(ctx context.Context, transactionID string) (models.Event, error)
+	List(context.Context, models.EventFilter) ([]models.Event, int, error)
 }
 
 type eventsResource struct {
@@ -38,11 +93,31 @@ func NewEventsResource(svc eventsService, resp SimpleResponder) *eventsResource
 }
 
 func (r StateResultEvent) validateToModel() (models.Event, error) {
-	amount, err := strconv.ParseFloat(r.Amount, 64)
+	amount, err := models.ParseMoney(r.Amount)
 	if err != nil {
 		return models.Event{}, apperrors.NewValidation("request", errors.New("Amount is not valid"))
 	}
 
+	currency, err := models.ParseCurrency(r.Currency)
+	if err != nil {
+		return models.Event{}, apperrors.NewValidation("request", errors.New("Currency is not supported"))
+	}
+	if !currency.Fits(amount) {
+		return models.Event{}, apperrors.NewValidation("request", errors.Errorf("Amount cannot have more than %d decimal places", currency.MinorUnits()))
+	}
+
+	var occurredAt time.Time
+	if r.OccurredAt != nil {
+		occurredAt = r.OccurredAt.UTC()
+		if occurredAt.After(time.Now().Add(maxClockSkew)) {
+			return models.Event{}, apperrors.NewValidation("request", errors.New("OccurredAt cannot be in the future"))
+		}
+	}
+
+	if err := r.Metadata.Validate(); err != nil {
+		return models.Event{}, apperrors.NewValidation("request", err)
+	}
+
 	state := models.EventState(strings.ToUpper(r.State))
 
 	if state == models.StateLoss && amount > 0 {
@@ -53,12 +128,91 @@ func (r StateResultEvent) validateToModel() (models.Event, error) {
 	}
 
 	return models.Event{
+		AccountID:     r.AccountID,
 		State:         state,
 		Amount:        amount,
+		Currency:      currency,
 		TransactionID: r.TransactionID,
+		OccurredAt:    occurredAt,
+		Metadata:      r.Metadata,
 	}, nil
 }
 
+func (q EventsQuery) toFilter() models.EventFilter {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PerPage == 0 {
+		q.PerPage = defaultPerPage
+	}
+	return models.EventFilter{
+		AccountID:   q.AccountID,
+		Currency:    models.Currency(strings.ToUpper(q.Currency)),
+		State:       models.EventState(strings.ToUpper(q.State)),
+		Status:      models.EventStatus(strings.ToUpper(q.Status)),
+		SourceType:  strings.ToLower(q.SourceType),
+		Time:        models.EventTime(q.OrderBy),
+		From:        q.From,
+		To:          q.To,
+		OldestFirst: q.Sort == "date",
+		Limit:       q.PerPage,
+		Offset:      (q.Page - 1) * q.PerPage,
+	}
+}
+
+func newEventView(e models.Event) EventView {
+	return EventView{
+		ID:                e.ID,
+		AccountID:         e.AccountID,
+		State:             strings.ToLower(string(e.State)),
+		Amount:            e.Amount,
+		Currency:          e.Currency,
+		TransactionID:     e.TransactionID,
+		Status:            strings.ToLower(string(e.Status)),
+		SourceType:        e.SourceType,
+		CreatedAt:         e.CreatedAt,
+		OccurredAt:        e.OccurredAt,
+		CancellationRunID: e.CancellationRunID,
+		Metadata:          e.Metadata,
+	}
+}
+
+// metadataFilter collects metadata conditions from query parameters
+func metadataFilter(params url.Values) (map[string]string, error) {
+	var filter map[string]string
+	for name, values := range params {
+		if !strings.HasPrefix(name, metadataParamPrefix) {
+			continue
+		}
+		path := strings.TrimPrefix(name, metadataParamPrefix)
+		if !validMetadataPath(path) {
+			return nil, apperrors.NewBadRequest(errors.Errorf("Invalid metadata path %q", path))
+		}
+		if filter == nil {
+			filter = make(map[string]string)
+		}
+		filter[path] = values[0]
+	}
+	if len(filter) > maxMetadataParams {
+		return nil, apperrors.NewBadRequest(errors.Errorf("At most %d metadata parameters are allowed", maxMetadataParams))
+	}
+	return filter, nil
+}
+
+// validMetadataPath checks path can reach a value within metadata depth limit
+func validMetadataPath(path string) bool {
+	keys := strings.Split(path, ".")
+	if len(keys) > models.MetadataMaxDepth {
+		return false
+	}
+	for _, k := range keys {
+		if k == "" || len(k) > models.MetadataMaxKeyLength {
+			return false
+		}
+	}
+	return true
+}
+
 // ProcessNewEvent processes incomig event
 func (r *eventsResource) ProcessNewEvent(c *gin.Context) {
 	var req StateResultEvent
@@ -71,7 +225,8 @@ func (r *eventsResource) ProcessNewEvent(c *gin.Context) {
 		c.Error(errors.WithStack(err))
 		return
 	}
-	err = r.svc.Create(c, event)
+	event.SourceType = strings.ToLower(c.GetHeader(middleware.SourceTypeHeader))
+	err = r.svc.Create(c.Request.Context(), event)
 	if err != nil {
 		c.Error(errors.WithStack(err))
 		return
@@ -80,3 +235,43 @@ func (r *eventsResource) ProcessNewEvent(c *gin.Context) {
 		"transactionId": event.TransactionID,
 	})
 }
+
+// GetEvent returns event by its transaction ID
+func (r *eventsResource) GetEvent(c *gin.Context) {
+	event, err := r.svc.Get(c.Request.Context(), c.Param("transactionId"))
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.OK(c, newEventView(event))
+}
+
+// ListEvents returns filtered page of events
+func (r *eventsResource) ListEvents(c *gin.Context) {
+	var q EventsQuery
+	err := c.ShouldBindQuery(&q)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	filter := q.toFilter()
+	filter.Metadata, err = metadataFilter(c.Request.URL.Query())
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	events, total, err := r.svc.List(c.Request.Context(), filter)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]EventView, 0, len(events))
+	for _, e := range events {
+		views = append(views, newEventView(e))
+	}
+	r.resp.List(c, views, &Pagination{
+		Total:       total,
+		PerPage:     filter.Limit,
+		CurrentPage: filter.Offset/filter.Limit + 1,
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/middleware"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+type stubEvents struct {
+	created []models.Event
+}
+
+func (s *stubEvents) Create(_ context.Context, e models.Event) error {
+	s.created = append(s.created, e)
+	return nil
+}
+
+func (s *stubEvents) CreateBatch(_ context.Context, events []models.Event, _ bool) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(events))
+	for i, e := range events {
+		s.created = append(s.created, e)
+		results[i] = models.BatchResult{Status: models.BatchCreated}
+	}
+	return results, nil
+}
+
+func (s *stubEvents) Get(_ context.Context, _ string) (models.Event, error) {
+	return models.Event{}, nil
+}
+
+func (s *stubEvents) List(_ context.Context, _ models.EventFilter) ([]models.Event, int, error) {
+	return nil, 0, nil
+}
+
+// Transaction ID longer than its column is rejected before reaching storage
+func TestTransactionIDTooLong(t *testing.T) {
+	a := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	responder := NewResponder()
+	svc := &stubEvents{}
+	res := NewEventsResource(svc, responder)
+	r := gin.New()
+	r.Use(middleware.ErrorHandler(responder))
+	r.POST("/event", res.ProcessNewEvent)
+	r.POST("/events/batch", res.ProcessBatch)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+	event := func(transactionID string) string {
+		return fmt.Sprintf(`{"accountId": 1, "state": "win", "amount": "1", "currency": "EUR", "transactionId": %q}`, transactionID)
+	}
+	tooLong := strings.Repeat("x", 129)
+
+	a.Equal(http.StatusUnprocessableEntity, post("/event", event(tooLong)).Code)
+	a.Empty(svc.created)
+	a.Equal(http.StatusCreated, post("/event", event(strings.Repeat("x", 128))).Code)
+
+	w := post("/events/batch", "["+event("tx-1")+","+event(tooLong)+","+event("tx-2")+"]")
+	a.Equal(http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Item BatchView `json:"item"`
+		} `json:"data"`
+	}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	statuses := make([]models.BatchStatus, 0, len(resp.Data.Item.Results))
+	for _, item := range resp.Data.Item.Results {
+		statuses = append(statuses, item.Status)
+	}
+	a.Equal([]models.BatchStatus{models.BatchCreated, models.BatchInvalid, models.BatchCreated}, statuses)
+	a.Len(svc.created, 3)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/sources"
+	"github.com/gin-gonic/gin"
+)
+
+// SourceTypeView is source type representation in responses, credentials are never shown
+type SourceTypeView struct {
+	Name           string        `json:"name"`
+	DisplayName    string        `json:"displayName"`
+	Enabled        bool          `json:"enabled"`
+	HasCredentials bool          `json:"hasCredentials"`
+	States         []string      `json:"states"`
+	MinAmount      *models.Money `json:"minAmount"`
+	MaxAmount      *models.Money `json:"maxAmount"`
+	LateWindow     string        `json:"lateWindow,omitempty"`
+}
+
+// ----------------------------------
+
+type sourcesRegistry interface {
+	List() []sources.Source
+}
+
+type sourcesResource struct {
+	registry sourcesRegistry
+	resp     SimpleResponder
+}
+
+// NewSourcesResource returns Source types API resource
+func NewSourcesResource(registry sourcesRegistry, resp SimpleResponder) *sourcesResource {
+	return &sourcesResource{
+		registry: registry,
+		resp:     resp,
+	}
+}
+
+// ListSourceTypes returns currently configured source types
+func (r *sourcesResource) ListSourceTypes(c *gin.Context) {
+	list := r.registry.List()
+	views := make([]SourceTypeView, 0, len(list))
+	for _, s := range list {
+		views = append(views, newSourceTypeView(s))
+	}
+	r.resp.OK(c, views)
+}
+
+func newSourceTypeView(s sources.Source) SourceTypeView {
+	v := SourceTypeView{
+		Name:           s.Name,
+		DisplayName:    s.DisplayName,
+		Enabled:        s.Enabled,
+		HasCredentials: len(s.Credentials) > 0,
+		States:         make([]string, 0, len(s.Rule.States)),
+	}
+	for _, state := range s.Rule.States {
+		v.States = append(v.States, string(state))
+	}
+	if s.Rule.MinAmount > 0 {
+		min := s.Rule.MinAmount
+		v.MinAmount = &min
+	}
+	if s.Rule.LateWindow > 0 {
+		v.LateWindow = s.Rule.LateWindow.String()
+	}
+	if s.Rule.MaxAmount > 0 {
+		max := s.Rule.MaxAmount
+		v.MaxAmount = &max
+	}
+	return v
+}
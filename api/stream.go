@@ -0,0 +1,106 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// StreamPath is path of the stream, it stays open without request deadline
+const StreamPath = "/stream"
+
+// StreamQuery filters streamed messages
+type StreamQuery struct {
+	AccountID  int    `form:"accountId" binding:"omitempty,min=1"`
+	SourceType string `form:"sourceType"`
+	// LastEventID resumes the stream for clients which can't send Last-Event-ID header, the header wins
+	LastEventID int64 `form:"lastEventId" binding:"omitempty,min=1"`
+}
+
+// ----------------------------------
+
+type streamHub interface {
+	Subscribe(f stream.Filter, lastID int64) (*stream.Subscription, bool)
+	Unsubscribe(*stream.Subscription)
+}
+
+type streamResource struct {
+	hub streamHub
+	// heartbeat is interval of comments keeping idle connection open through proxies, zero disables them
+	heartbeat time.Duration
+}
+
+// NewStreamResource returns Stream API resource
+func NewStreamResource(hub streamHub, heartbeat time.Duration) *streamResource {
+	return &streamResource{
+		hub:       hub,
+		heartbeat: heartbeat,
+	}
+}
+
+// Stream pushes event and balance changes as Server-Sent Events until the client disconnects
+func (r *streamResource) Stream(c *gin.Context) {
+	var q StreamQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	lastID := q.LastEventID
+	if h := c.GetHeader("Last-Event-ID"); h != "" {
+		id, err := strconv.ParseInt(h, 10, 64)
+		if err != nil {
+			c.Error(errors.WithStack(err))
+			return
+		}
+		lastID = id
+	}
+	sub, resumed := r.hub.Subscribe(stream.Filter{AccountID: q.AccountID, SourceType: q.SourceType}, lastID)
+	defer r.hub.Unsubscribe(sub)
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	// keeps nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if !resumed {
+		c.Render(-1, sse.Event{Event: stream.ResetEvent, Data: gin.H{"lastEventId": lastID}})
+	} else {
+		// headers are sent at once, so the client knows the stream is open
+		io.WriteString(c.Writer, ": open\n\n")
+	}
+	c.Writer.Flush()
+	var heartbeat <-chan time.Time
+	if r.heartbeat > 0 {
+		t := time.NewTicker(r.heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+	done := c.Request.Context().Done()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-done:
+			return false
+		case m, ok := <-sub.C():
+			if !ok {
+				// the client reconnects and resumes from the last received message
+				return false
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(m.ID, 10),
+				Event: stream.EventName(m),
+				Data:  m.Payload,
+			})
+			return true
+		case <-heartbeat:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookEndpointRequest registers webhook endpoint, empty filter matches any event
+type WebhookEndpointRequest struct {
+	URL string `json:"url" binding:"required,url"`
+	// Secret signs notifications, generated if empty
+	Secret      string   `json:"secret" binding:"omitempty,min=16,max=128"`
+	States      []string `json:"states" binding:"omitempty,dive,oneof=win loss"`
+	Statuses    []string `json:"statuses" binding:"omitempty,dive,oneof=processed canceled"`
+	SourceTypes []string `json:"sourceTypes"`
+}
+
+// WebhookEndpointView is endpoint representation in responses, secret is shown on creation only
+type WebhookEndpointView struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	States      []string  `json:"states"`
+	Statuses    []string  `json:"statuses"`
+	SourceTypes []string  `json:"sourceTypes"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Failures counts attempts failed in a row, DisabledAt is set once there are too many of them
+	Failures   int        `json:"failures"`
+	DisabledAt *time.Time `json:"disabledAt"`
+}
+
+// DeadLettersQuery selects page of dead letters, of all endpoints unless endpointId is given
+type DeadLettersQuery struct {
+	EndpointID int `form:"endpointId" binding:"omitempty,min=1"`
+	Page       int `form:"page" binding:"omitempty,min=1"`
+	PerPage    int `form:"perPage" binding:"omitempty,min=1,max=100"`
+}
+
+// DeadLetterView is dead letter representation in responses
+type DeadLetterView struct {
+	ID         int64           `json:"id"`
+	EndpointID int             `json:"endpointId"`
+	Key        string          `json:"key"`
+	Topic      string          `json:"topic"`
+	Payload    json.RawMessage `json:"payload"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"lastError"`
+	FailedAt   time.Time       `json:"failedAt"`
+}
+
+// ReplayRequest selects dead letters to replay, all of them if empty
+type ReplayRequest struct {
+	EndpointID int     `json:"endpointId" binding:"omitempty,min=1"`
+	IDs        []int64 `json:"ids" binding:"omitempty,max=100"`
+}
+
+// EnableEndpointRequest selects endpoint disabled after failures to enable
+type EnableEndpointRequest struct {
+	EndpointID int `json:"endpointId" binding:"required,min=1"`
+}
+
+// ----------------------------------
+
+type webhooksService interface {
+	CreateEndpoint(context.Context, webhooks.Endpoint) (webhooks.Endpoint, error)
+	ListEndpoints(context.Context) ([]webhooks.Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	EnableEndpoint(ctx context.Context, id int) error
+	ListDeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error)
+	ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64) (int, error)
+}
+
+type webhooksResource struct {
+	svc  webhooksService
+	resp SimpleResponder
+}
+
+// NewWebhooksResource returns Webhooks admin API resource
+func NewWebhooksResource(svc webhooksService, resp SimpleResponder) *webhooksResource {
+	return &webhooksResource{
+		svc:  svc,
+		resp: resp,
+	}
+}
+
+// CreateEndpoint registers webhook endpoint
+func (r *webhooksResource) CreateEndpoint(c *gin.Context) {
+	var req WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	ep, err := r.svc.CreateEndpoint(c.Request.Context(), req.toModel())
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	v := newWebhookEndpointView(ep)
+	v.Secret = ep.Secret
+	r.resp.Created(c, v)
+}
+
+// ListEndpoints returns registered webhook endpoints
+func (r *webhooksResource) ListEndpoints(c *gin.Context) {
+	endpoints, err := r.svc.ListEndpoints(c.Request.Context())
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]WebhookEndpointView, 0, len(endpoints))
+	for _, ep := range endpoints {
+		views = append(views, newWebhookEndpointView(ep))
+	}
+	r.resp.OK(c, views)
+}
+
+// DeleteEndpoint removes webhook endpoint with its pending deliveries
+func (r *webhooksResource) DeleteEndpoint(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	if err := r.svc.DeleteEndpoint(c.Request.Context(), id); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.OK(c, gin.H{"id": id})
+}
+
+// EnableEndpoint lets deliveries to webhook endpoint disabled after failures go again
+func (r *webhooksResource) EnableEndpoint(c *gin.Context) {
+	var req EnableEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	if err := r.svc.EnableEndpoint(c.Request.Context(), req.EndpointID); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.OK(c, gin.H{"id": req.EndpointID})
+}
+
+// ListDeadLetters returns page of deliveries which failed every attempt, the latest first
+func (r *webhooksResource) ListDeadLetters(c *gin.Context) {
+	var q DeadLettersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PerPage == 0 {
+		q.PerPage = defaultPerPage
+	}
+	letters, total, err := r.svc.ListDeadLetters(c.Request.Context(), q.EndpointID, q.PerPage, (q.Page-1)*q.PerPage)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	views := make([]DeadLetterView, 0, len(letters))
+	for _, l := range letters {
+		views = append(views, DeadLetterView{
+			ID:         l.ID,
+			EndpointID: l.EndpointID,
+			Key:        l.Key,
+			Topic:      l.Topic,
+			Payload:    l.Payload,
+			CreatedAt:  l.CreatedAt,
+			Attempts:   l.Attempts,
+			LastError:  l.LastError,
+			FailedAt:   l.FailedAt,
+		})
+	}
+	r.resp.List(c, views, &Pagination{
+		Total:       total,
+		PerPage:     q.PerPage,
+		CurrentPage: q.Page,
+	})
+}
+
+// ReplayDeadLetters queues dead letters for delivery again
+func (r *webhooksResource) ReplayDeadLetters(c *gin.Context) {
+	var req ReplayRequest
+	// empty body replays everything
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	n, err := r.svc.ReplayDeadLetters(c.Request.Context(), req.EndpointID, req.IDs)
+	if err != nil {
+		c.Error(errors.WithStack(err))
+		return
+	}
+	r.resp.OK(c, gin.H{"replayed": n})
+}
+
+func (r WebhookEndpointRequest) toModel() webhooks.Endpoint {
+	ep := webhooks.Endpoint{
+		URL:         r.URL,
+		Secret:      r.Secret,
+		SourceTypes: r.SourceTypes,
+	}
+	for _, s := range r.States {
+		ep.States = append(ep.States, models.EventState(strings.ToUpper(s)))
+	}
+	for _, s := range r.Statuses {
+		ep.Statuses = append(ep.Statuses, models.EventStatus(strings.ToUpper(s)))
+	}
+	return ep
+}
+
+func newWebhookEndpointView(ep webhooks.Endpoint) WebhookEndpointView {
+	v := WebhookEndpointView{
+		ID:          ep.ID,
+		URL:         ep.URL,
+		States:      make([]string, 0, len(ep.States)),
+		Statuses:    make([]string, 0, len(ep.Statuses)),
+		SourceTypes: append([]string{}, ep.SourceTypes...),
+		CreatedAt:   ep.CreatedAt,
+		Failures:    ep.Failures,
+		DisabledAt:  ep.DisabledAt,
+	}
+	for _, s := range ep.States {
+		v.States = append(v.States, strings.ToLower(string(s)))
+	}
+	for _, s := range ep.Statuses {
+		v.Statuses = append(v.Statuses, strings.ToLower(string(s)))
+	}
+	return v
+}
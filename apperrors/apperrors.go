@@ -12,6 +12,9 @@ type NotFound struct{ SimpleError }
 
 type BadRequest struct{ SimpleError }
 
+// Unauthorized is returned when request lacks valid credentials
+type Unauthorized struct{ SimpleError }
+
 func NewNotFound(err error) *NotFound {
 	return &NotFound{SimpleError{err}}
 }
@@ -19,3 +22,22 @@ func NewNotFound(err error) *NotFound {
 func NewBadRequest(err error) *BadRequest {
 	return &BadRequest{SimpleError{err}}
 }
+
+func NewUnauthorized(err error) *Unauthorized {
+	return &Unauthorized{SimpleError{err}}
+}
+
+// Conflict is returned when request contradicts already stored data
+type Conflict struct {
+	SimpleError
+	fields []string
+}
+
+func NewConflict(err error, fields []string) *Conflict {
+	return &Conflict{SimpleError{err}, fields}
+}
+
+// Fields returns names of conflicting fields
+func (e *Conflict) Fields() []string {
+	return e.fields
+}
@@ -0,0 +1,24 @@
+package cancellation
+
+import (
+	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// defaultNumber keeps former behavior for configs without cancellation section
+const defaultNumber = 10
+
+// FromConfig returns strategy configured for cancellation task
+func FromConfig(c config.CancellationConfig) (Strategy, error) {
+	p := Params{
+		Number:     c.Number,
+		OlderThan:  c.OlderThan,
+		SourceType: c.SourceType,
+		State:      models.EventState(c.State),
+		OrderBy:    models.EventTime(c.OrderBy),
+	}
+	if p.Number == 0 && c.Strategy != NameOlderThan {
+		p.Number = defaultNumber
+	}
+	return New(c.Strategy, p)
+}
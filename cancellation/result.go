@@ -0,0 +1,69 @@
+package cancellation
+
+import (
+	"time"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// Options changes how cancellation run is applied
+type Options struct {
+	// DryRun computes the result without changing anything
+	DryRun bool
+	// Partial cancels the events which keep balance non-negative
+	// instead of failing the whole run
+	Partial bool
+	// RunID links canceled events to the run, zero leaves them unlinked
+	RunID int
+}
+
+// Result describes cancellation run in one wallet
+type Result struct {
+	Wallet models.Wallet
+	// EventIDs are events canceled, or to be canceled in dry run
+	EventIDs []int
+	// SkippedIDs are chosen events left untouched in partial mode
+	SkippedIDs    []int
+	BalanceBefore models.Money
+	BalanceAfter  models.Money
+	DryRun        bool
+	// Err tells why the run failed in the wallet
+	Err error
+}
+
+// Run is one cancellation run over all wallets
+type Run struct {
+	ID       int
+	Strategy string
+	Params   map[string]interface{}
+	Partial  bool
+	// FinishedAt is zero while the run is in progress or if it was interrupted
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Results    []Result
+	Err        error
+}
+
+// Fit splits chosen events into the ones which can be canceled keeping balance non-negative
+// and the skipped ones. Losses are always canceled as they only raise the balance,
+// wins are taken in the given order while they fit.
+func Fit(chosen []models.Event, balance models.Money) (canceled, skipped []models.Event) {
+	for _, e := range chosen {
+		if e.Amount < 0 {
+			balance -= e.Amount
+		}
+	}
+	for _, e := range chosen {
+		if e.Amount < 0 {
+			canceled = append(canceled, e)
+			continue
+		}
+		if balance-e.Amount < 0 {
+			skipped = append(skipped, e)
+			continue
+		}
+		balance -= e.Amount
+		canceled = append(canceled, e)
+	}
+	return canceled, skipped
+}
@@ -0,0 +1,32 @@
+package cancellation
+
+import (
+	"testing"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFit(t *testing.T) {
+	a := assert.New(t)
+
+	win4 := models.Event{ID: 4, Amount: models.MoneyFromInt(4)}
+	win3 := models.Event{ID: 3, Amount: models.MoneyFromInt(3)}
+	loss2 := models.Event{ID: 2, Amount: models.MoneyFromInt(-2)}
+	win1 := models.Event{ID: 1, Amount: models.MoneyFromInt(1)}
+	chosen := []models.Event{win4, win3, loss2, win1}
+
+	// loss cancellation pays for the newest wins
+	canceled, skipped := Fit(chosen, models.MoneyFromInt(5))
+	a.Equal([]models.Event{win4, win3, loss2}, canceled)
+	a.Equal([]models.Event{win1}, skipped)
+
+	// smaller win still fits after a larger one is skipped
+	canceled, skipped = Fit(chosen, models.MoneyFromInt(1))
+	a.Equal([]models.Event{win3, loss2}, canceled)
+	a.Equal([]models.Event{win4, win1}, skipped)
+
+	canceled, skipped = Fit(chosen, models.MoneyFromInt(6))
+	a.Equal(chosen, canceled)
+	a.Empty(skipped)
+}
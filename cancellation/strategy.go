@@ -0,0 +1,227 @@
+package cancellation
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// Strategy chooses events of the wallet to cancel
+type Strategy interface {
+	// Name identifies strategy in config and reports
+	Name() string
+	// Params returns strategy parameters for reports
+	Params() map[string]interface{}
+	// Candidates returns filter of events the strategy chooses from.
+	// Storage lists them newest first unless the filter says otherwise.
+	Candidates(w models.Wallet, now time.Time) models.EventFilter
+	// Select picks events to cancel from candidates.
+	// Total is the number of all events matching the filter regardless of its limit.
+	Select(candidates []models.Event, total int) []models.Event
+}
+
+// Names of built-in strategies
+const (
+	NameOddRows    = "odd-rows"
+	NameLast       = "last"
+	NameOlderThan  = "older-than"
+	NameSourceType = "source-type"
+	NameState      = "state"
+)
+
+// Params holds parameters of built-in strategies, each strategy uses only some of them
+type Params struct {
+	// Number limits events canceled per wallet in one run
+	Number     int
+	OlderThan  time.Duration
+	SourceType string
+	State      models.EventState
+	// OrderBy chooses time which orders events and limits their age, ingestion time by default
+	OrderBy models.EventTime
+}
+
+// New returns built-in strategy by its name
+func New(name string, p Params) (Strategy, error) {
+	switch p.OrderBy {
+	case "", models.IngestionTime:
+		return newStrategy(name, p)
+	case models.OccurrenceTime:
+		s, err := newStrategy(name, p)
+		if err != nil {
+			return nil, err
+		}
+		return OrderedBy(s, p.OrderBy), nil
+	}
+	return nil, errors.Errorf("Unknown cancellation order %q", p.OrderBy)
+}
+
+func newStrategy(name string, p Params) (Strategy, error) {
+	if p.Number <= 0 && name != NameOlderThan {
+		return nil, errors.Errorf("Cancellation strategy %q requires positive number", name)
+	}
+	switch name {
+	case NameOddRows, "":
+		return OddRows(p.Number), nil
+	case NameLast:
+		return Last(p.Number), nil
+	case NameOlderThan:
+		if p.OlderThan <= 0 {
+			return nil, errors.Errorf("Cancellation strategy %q requires positive duration", name)
+		}
+		return OlderThan(p.OlderThan, p.Number), nil
+	case NameSourceType:
+		if p.SourceType == "" {
+			return nil, errors.Errorf("Cancellation strategy %q requires source type", name)
+		}
+		return BySourceType(p.SourceType, p.Number), nil
+	case NameState:
+		state := models.EventState(strings.ToUpper(string(p.State)))
+		if state != models.StateWin && state != models.StateLoss {
+			return nil, errors.Errorf("Cancellation strategy %q requires state", name)
+		}
+		return ByState(state, p.Number), nil
+	}
+	return nil, errors.Errorf("Unknown cancellation strategy %q", name)
+}
+
+type oddRows struct {
+	number int
+}
+
+// OddRows looks at the last number*2 events of the wallet
+// and cancels not yet canceled ones with odd row number
+func OddRows(number int) Strategy {
+	return oddRows{number: number}
+}
+
+func (s oddRows) Name() string {
+	return NameOddRows
+}
+
+func (s oddRows) Params() map[string]interface{} {
+	return map[string]interface{}{"number": s.number}
+}
+
+func (s oddRows) Candidates(w models.Wallet, _ time.Time) models.EventFilter {
+	return models.EventFilter{
+		AccountID: w.AccountID,
+		Currency:  w.Currency,
+		Limit:     s.number * 2,
+	}
+}
+
+func (s oddRows) Select(candidates []models.Event, total int) []models.Event {
+	selected := make([]models.Event, 0, s.number)
+	for i, e := range candidates {
+		// newest event has the last row number
+		rowNumber := total - i
+		// skip already canceled and EVEN records
+		if e.Status == models.StatusCanceled || rowNumber%2 == 0 {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	return selected
+}
+
+// filtered cancels every processed event matching its filter
+type filtered struct {
+	name   string
+	params map[string]interface{}
+	filter func(now time.Time) models.EventFilter
+}
+
+// Last cancels the last number processed events
+func Last(number int) Strategy {
+	return filtered{
+		name:   NameLast,
+		params: map[string]interface{}{"number": number},
+		filter: func(time.Time) models.EventFilter {
+			return models.EventFilter{Limit: number}
+		},
+	}
+}
+
+// OlderThan cancels processed events ingested more than age ago,
+// at most number of them when it's positive
+func OlderThan(age time.Duration, number int) Strategy {
+	return filtered{
+		name:   NameOlderThan,
+		params: map[string]interface{}{"olderThan": age.String(), "number": number},
+		filter: func(now time.Time) models.EventFilter {
+			return models.EventFilter{To: now.Add(-age), Limit: number}
+		},
+	}
+}
+
+// BySourceType cancels the last number processed events of the source type
+func BySourceType(sourceType string, number int) Strategy {
+	sourceType = strings.ToLower(sourceType)
+	return filtered{
+		name:   NameSourceType,
+		params: map[string]interface{}{"sourceType": sourceType, "number": number},
+		filter: func(time.Time) models.EventFilter {
+			return models.EventFilter{SourceType: sourceType, Limit: number}
+		},
+	}
+}
+
+// ByState cancels the last number processed events with the state
+func ByState(state models.EventState, number int) Strategy {
+	return filtered{
+		name:   NameState,
+		params: map[string]interface{}{"state": state, "number": number},
+		filter: func(time.Time) models.EventFilter {
+			return models.EventFilter{State: state, Limit: number}
+		},
+	}
+}
+
+func (s filtered) Name() string {
+	return s.name
+}
+
+func (s filtered) Params() map[string]interface{} {
+	return s.params
+}
+
+func (s filtered) Candidates(w models.Wallet, now time.Time) models.EventFilter {
+	f := s.filter(now)
+	f.AccountID = w.AccountID
+	f.Currency = w.Currency
+	f.Status = models.StatusProcessed
+	return f
+}
+
+func (s filtered) Select(candidates []models.Event, _ int) []models.Event {
+	return candidates
+}
+
+type ordered struct {
+	Strategy
+	by models.EventTime
+}
+
+// OrderedBy makes strategy choose events by the time instead of ingestion order,
+// like the last events to occur or the ones which occurred long ago
+func OrderedBy(s Strategy, by models.EventTime) Strategy {
+	return ordered{Strategy: s, by: by}
+}
+
+func (s ordered) Params() map[string]interface{} {
+	params := make(map[string]interface{}, len(s.Strategy.Params())+1)
+	for k, v := range s.Strategy.Params() {
+		params[k] = v
+	}
+	params["orderBy"] = s.by
+	return params
+}
+
+func (s ordered) Candidates(w models.Wallet, now time.Time) models.EventFilter {
+	f := s.Strategy.Candidates(w, now)
+	f.Time = s.by
+	return f
+}
@@ -0,0 +1,90 @@
+package cancellation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	a := assert.New(t)
+
+	valid := []struct {
+		name string
+		p    Params
+	}{
+		{NameOddRows, Params{Number: 10}},
+		{"", Params{Number: 10}},
+		{NameLast, Params{Number: 5}},
+		{NameOlderThan, Params{OlderThan: time.Hour}},
+		{NameSourceType, Params{Number: 5, SourceType: "Payment"}},
+		{NameState, Params{Number: 5, State: "win"}},
+		{NameLast, Params{Number: 5, OrderBy: models.OccurrenceTime}},
+	}
+	for _, c := range valid {
+		s, err := New(c.name, c.p)
+		a.NoError(err, c.name)
+		a.NotNil(s, c.name)
+	}
+
+	invalid := []struct {
+		name string
+		p    Params
+	}{
+		{NameOddRows, Params{}},
+		{NameLast, Params{Number: -1}},
+		{NameOlderThan, Params{Number: 5}},
+		{NameSourceType, Params{Number: 5}},
+		{NameState, Params{Number: 5, State: "DRAW"}},
+		{"random", Params{Number: 5}},
+		{NameLast, Params{Number: 5, OrderBy: "random"}},
+	}
+	for _, c := range invalid {
+		_, err := New(c.name, c.p)
+		a.Error(err, c.name)
+	}
+}
+
+func TestOddRowsSelect(t *testing.T) {
+	a := assert.New(t)
+
+	// 5 events in total, the last 4 listed newest first, rows 5..2
+	candidates := []models.Event{
+		{ID: 5, Status: models.StatusProcessed},
+		{ID: 4, Status: models.StatusProcessed},
+		{ID: 3, Status: models.StatusCanceled},
+		{ID: 2, Status: models.StatusProcessed},
+	}
+	selected := OddRows(2).Select(candidates, 5)
+	a.Equal([]models.Event{candidates[0]}, selected)
+}
+
+func TestCandidates(t *testing.T) {
+	a := assert.New(t)
+	w := models.Wallet{AccountID: 1, Currency: "EUR"}
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	f := OddRows(10).Candidates(w, now)
+	a.Equal(models.EventFilter{AccountID: 1, Currency: "EUR", Limit: 20}, f)
+
+	f = OlderThan(24*time.Hour, 0).Candidates(w, now)
+	a.Equal(models.EventFilter{
+		AccountID: 1,
+		Currency:  "EUR",
+		Status:    models.StatusProcessed,
+		To:        now.Add(-24 * time.Hour),
+	}, f)
+
+	f = BySourceType("Payment", 3).Candidates(w, now)
+	a.Equal("payment", f.SourceType)
+	a.Equal(3, f.Limit)
+
+	s := OrderedBy(OlderThan(24*time.Hour, 0), models.OccurrenceTime)
+	f = s.Candidates(w, now)
+	a.Equal(models.OccurrenceTime, f.Time)
+	a.Equal(now.Add(-24*time.Hour), f.To)
+	a.Equal(NameOlderThan, s.Name())
+	a.Equal(models.OccurrenceTime, s.Params()["orderBy"])
+}
@@ -1,41 +1,73 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/djumpen/test-ex-go/api"
+	"github.com/djumpen/test-ex-go/cancellation"
 	"github.com/djumpen/test-ex-go/config"
 	"github.com/djumpen/test-ex-go/middleware"
+	"github.com/djumpen/test-ex-go/migrations"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+	"github.com/djumpen/test-ex-go/scheduler"
 	"github.com/djumpen/test-ex-go/services"
+	"github.com/djumpen/test-ex-go/sources"
 	"github.com/djumpen/test-ex-go/storage"
+	"github.com/djumpen/test-ex-go/stream"
 	"github.com/djumpen/test-ex-go/validation"
+	"github.com/djumpen/test-ex-go/webhooks"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/jinzhu/gorm"
 	_ "github.com/lib/pq"
-	migrate "github.com/rubenv/sql-migrate"
 )
 
 func main() {
 	cfg := config.GetConfig()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if cfg.ReleaseMode {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	gormDB, err := gorm.Open("postgres", config.GetPostgresConnection())
+	st, err := openStorage(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	strategy, err := cancellation.FromConfig(cfg.Cancellation)
 	if err != nil {
 		log.Fatal(err)
 	}
+	cancellationOpts := cancellation.Options{Partial: cfg.Cancellation.Partial}
 
-	err = applyMigrations(gormDB.DB())
+	sourceTypes, err := sources.NewRegistry(cfg.SourceTypes)
 	if err != nil {
 		log.Fatal(err)
 	}
+	config.Watch(func(c config.Config) {
+		if err := sourceTypes.Reload(c.SourceTypes); err != nil {
+			log.Printf("Source types are not reloaded: %s", err)
+			return
+		}
+		log.Print("Source types reloaded")
+	})
 
 	// Upgrade gin validator
 	binding.Validator = new(validation.DefaultValidator)
@@ -45,46 +77,370 @@ func main() {
 	r := gin.Default()
 	r.RedirectTrailingSlash = true
 
+	// stream stays open until the client disconnects
+	routeTimeout := func(method, path string) time.Duration {
+		if path == api.StreamPath {
+			return 0
+		}
+		return cfg.RouteTimeout(method, path)
+	}
 	r.Use(
 		cors.New(api.GetCorsConfig()),
 		middleware.ErrorHandler(responder),
+		middleware.Deadline(routeTimeout),
 	)
 
 	rValidHeader := r.Group("/",
-		middleware.ValidateSourceType(responder),
+		middleware.ValidateSourceType(responder, sourceTypes),
+	)
+	if len(cfg.AdminTokens) == 0 {
+		log.Print("No adminTokens configured, admin API rejects every request")
+	}
+	admin := r.Group("/admin",
+		middleware.RequireToken(responder, cfg.AdminTokens),
+	)
+	read := r.Group("/",
+		middleware.RequireToken(responder, append(append([]string{}, cfg.ReadTokens...), cfg.AdminTokens...)),
 	)
 
-	eventsStorage := storage.NewEvents(gormDB)
-	eventsSvc := services.NewEvents(eventsStorage)
+	eventsSvc := services.NewEvents(st.events, st.runs, st.cancellationLock, sourceTypes)
+	balanceSvc := services.NewBalance(st.accounts)
+	accountsSvc := services.NewAccounts(st.accounts)
+	webhooksSvc := services.NewWebhooks(st.webhooks, sourceTypes)
 
 	commonRes := api.NewCommonResource(responder)
 	eventsRes := api.NewEventsResource(eventsSvc, responder)
+	balanceRes := api.NewBalanceResource(balanceSvc, responder)
+	accountsRes := api.NewAccountsResource(accountsSvc, responder)
+	cancellationRes := api.NewCancellationResource(eventsSvc, responder, strategy, cancellationOpts)
+	sourcesRes := api.NewSourcesResource(sourceTypes, responder)
+	webhooksRes := api.NewWebhooksResource(webhooksSvc, responder)
+	hub := stream.NewHub(cfg.Stream.BufferSize)
+	streamRes := api.NewStreamResource(hub, time.Duration(cfg.Stream.Heartbeat)*time.Second)
 
 	// Setup routes
 	rValidHeader.POST("/event", eventsRes.ProcessNewEvent)
+	rValidHeader.POST("/events/batch", eventsRes.ProcessBatch)
+	read.GET("/event/:transactionId", eventsRes.GetEvent)
+	read.GET("/events", eventsRes.ListEvents)
+	read.GET("/balance", balanceRes.GetBalance)
 	r.GET("/health", commonRes.Health)
+	if cfg.Stream.Enabled {
+		if len(cfg.Stream.Tokens) == 0 {
+			log.Print("No stream tokens configured, stream rejects every client")
+		}
+		r.GET(api.StreamPath, middleware.RequireStreamToken(responder, cfg.Stream.Tokens), streamRes.Stream)
+	}
+	admin.POST("/accounts", accountsRes.CreateAccount)
+	admin.POST("/accounts/:id/currencies", accountsRes.AddCurrency)
+	admin.GET("/cancellation/preview", cancellationRes.PreviewCancellation)
+	admin.GET("/cancellation-runs", cancellationRes.ListRuns)
+	admin.GET("/source-types", sourcesRes.ListSourceTypes)
+	admin.POST("/webhooks", webhooksRes.CreateEndpoint)
+	admin.GET("/webhooks", webhooksRes.ListEndpoints)
+	admin.DELETE("/webhooks/:id", webhooksRes.DeleteEndpoint)
+	admin.POST("/webhooks/enable", webhooksRes.EnableEndpoint)
+	admin.GET("/webhooks/dead-letters", webhooksRes.ListDeadLetters)
+	admin.POST("/webhooks/dead-letters/replay", webhooksRes.ReplayDeadLetters)
 	r.NoRoute(commonRes.NotFound)
 
-	useSSL := cfg.CertFile != "" && cfg.KeyFile != ""
-	address := fmt.Sprintf(":%d", cfg.Port)
+	ctx, stop := scheduler.StopOnSignals(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	if useSSL {
-		if err := http.ListenAndServeTLS(address, cfg.CertFile, cfg.KeyFile, r); err != nil {
-			log.Fatalf("error in ListenAndServe: %s", err)
+	var background sync.WaitGroup
+	if cfg.AppCancellation {
+		schedule, err := scheduler.Parse(cfg.CancellationScheduleSpec())
+		if err != nil {
+			log.Fatal(err)
+		}
+		sched := scheduler.New()
+		sched.Add(scheduler.Job{
+			Name:     "cancellation",
+			Schedule: schedule,
+			Jitter:   time.Duration(cfg.CancellationJitter) * time.Second,
+			Timeout:  time.Duration(cfg.CancellationTimeout) * time.Second,
+			Run: func(ctx context.Context) error {
+				return eventsSvc.ExecCancellation(ctx, strategy, cancellationOpts)
+			},
+		})
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sched.Run(ctx)
+			if err := eventsSvc.ReleaseCancellation(context.Background()); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	// every consumer is relayed on its own, so a failing one doesn't hold up the others
+	consumers := make(map[string]outbox.Publisher)
+	if cfg.Outbox.Publisher != "" {
+		pub, err := newPublisher(cfg.Outbox)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consumers[outbox.ConsumerPublisher] = pub
+	}
+	if cfg.Webhooks.Enabled {
+		consumers[outbox.ConsumerWebhooks] = webhooks.NewPublisher(st.webhooks)
+	}
+	if cfg.Stream.Enabled {
+		// replicas sharing Postgres get messages through it, whichever of them relays
+		if st.notifier != nil {
+			consumers[outbox.ConsumerStream] = st.notifier
+		} else {
+			consumers[outbox.ConsumerStream] = hub
 		}
+	}
+	if len(consumers) > 0 {
+		schedule, err := scheduler.Parse(cfg.Outbox.Schedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sched := scheduler.New()
+		var releases []func(context.Context) error
+		for consumer, pub := range consumers {
+			relay := outbox.NewRelay(st.outbox, consumer, pub, st.outboxLock(consumer), cfg.Outbox.BatchSize)
+			releases = append(releases, relay.Release)
+			sched.Add(scheduler.Job{
+				Name:     outbox.LockName(consumer),
+				Schedule: schedule,
+				Run: func(ctx context.Context) error {
+					_, err := relay.Relay(ctx)
+					return err
+				},
+			})
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sched.Run(ctx)
+			for _, release := range releases {
+				if err := release(context.Background()); err != nil {
+					log.Print(err)
+				}
+			}
+		}()
+	}
+
+	if cfg.Webhooks.Enabled {
+		schedule, err := scheduler.Parse(cfg.Webhooks.Schedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client := &http.Client{Timeout: time.Duration(cfg.Webhooks.Timeout) * time.Millisecond}
+		dispatcher := webhooks.NewDispatcher(st.webhooks, client, st.webhooksLock, webhooks.RetryPolicy{
+			MaxAttempts:  cfg.Webhooks.MaxAttempts,
+			Backoff:      cfg.Webhooks.Backoff,
+			MaxBackoff:   cfg.Webhooks.MaxBackoff,
+			DisableAfter: cfg.Webhooks.DisableAfter,
+		}, cfg.Webhooks.BatchSize)
+		sched := scheduler.New()
+		sched.Add(scheduler.Job{
+			Name:     "webhooks",
+			Schedule: schedule,
+			Run: func(ctx context.Context) error {
+				_, err := dispatcher.Dispatch(ctx)
+				return err
+			},
+		})
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sched.Run(ctx)
+			if err := dispatcher.Release(context.Background()); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	if cfg.Stream.Enabled && st.listener != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			err := st.listener.Listen(ctx, func(m outbox.Message) {
+				hub.Publish(ctx, m)
+			}, hub.Reset)
+			if err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: r,
+	}
+	// open streams would keep shutdown waiting
+	srv.RegisterOnShutdown(hub.Close)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		<-ctx.Done()
+		// requests in progress are finished before exit
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error in Shutdown: %s", err)
+		}
+	}()
+
+	useSSL := cfg.CertFile != "" && cfg.KeyFile != ""
+	if useSSL {
+		err = srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
 	} else {
 		log.Printf("Listening on port %d\n", cfg.Port)
-		if err := http.ListenAndServe(address, r); err != nil {
-			log.Fatalf("error in ListenAndServe: %s", err)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatalf("error in ListenAndServe: %s", err)
+	}
+	background.Wait()
+}
+
+// shutdownTimeout limits waiting for requests in progress on stop
+const shutdownTimeout = 30 * time.Second
+
+type eventsStorage interface {
+	Create(context.Context, models.Event) error
+	CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error)
+	CancelEvents(context.Context, models.Wallet, cancellation.Strategy, cancellation.Options) (cancellation.Result, error)
+	Wallets(context.Context) ([]models.Wallet, error)
+	Get(ctx context.Context, transactionID string) (models.Event, error)
+	List(context.Context, models.EventFilter) ([]models.Event, int, error)
+}
+
+type runsStorage interface {
+	StartRun(context.Context, cancellation.Run) (int, error)
+	FinishRun(context.Context, cancellation.Run) error
+	ListRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error)
+}
+
+type locker interface {
+	TryLock(context.Context) (bool, error)
+	Unlock(context.Context) error
+}
+
+type outboxStorage interface {
+	Pending(ctx context.Context, consumer string, limit int) ([]outbox.Message, error)
+	MarkPublished(ctx context.Context, consumer string, ids []int64) error
+}
+
+type webhooksStorage interface {
+	CreateEndpoint(context.Context, webhooks.Endpoint) (webhooks.Endpoint, error)
+	Endpoints(context.Context) ([]webhooks.Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	EnqueueDeliveries(context.Context, []webhooks.Delivery) error
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhooks.Delivery, error)
+	MarkDelivered(ctx context.Context, id int64, at time.Time) error
+	RetryDelivery(ctx context.Context, id int64, next time.Time, lastErr string) error
+	BuryDelivery(ctx context.Context, id int64, at time.Time, lastErr string) error
+	EndpointFailed(ctx context.Context, id int, at time.Time, disableAfter int) (bool, error)
+	EndpointSucceeded(ctx context.Context, id int) error
+	EnableEndpoint(ctx context.Context, id int) error
+	DeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error)
+	ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64, at time.Time) (int, error)
+}
+
+type listener interface {
+	Listen(ctx context.Context, onMessage func(outbox.Message), onGap func()) error
+}
+
+type accountsStorage interface {
+	CreateAccount(ctx context.Context, currencies ...models.Currency) (int, error)
+	AddCurrency(ctx context.Context, w models.Wallet) error
+	AccountBalances(ctx context.Context, accountID int) ([]models.Balance, error)
+}
+
+// storages holds storage implementations for all services
+type storages struct {
+	events   eventsStorage
+	accounts accountsStorage
+	runs     runsStorage
+	// cancellationLock elects the process running cancellation
+	cancellationLock locker
+	outbox           outboxStorage
+	// outboxLock returns lock electing the process relaying outbox to the consumer
+	outboxLock func(consumer string) locker
+	webhooks   webhooksStorage
+	// webhooksLock elects the process dispatching webhooks
+	webhooksLock locker
+	// notifier and listener share relayed messages between replicas, nil if there is only one
+	notifier outbox.Publisher
+	listener listener
+}
+
+func openStorage(cfg config.Config) (storages, error) {
+	if cfg.Storage == config.StorageMemory {
+		st := storage.NewMemory()
+		// the same account Postgres migrations start with
+		if _, err := st.CreateAccount(context.Background(), "EUR"); err != nil {
+			return storages{}, err
+		}
+		log.Print("Using in-memory storage, all data will be lost on exit")
+		return storages{
+			events:           st,
+			accounts:         st,
+			runs:             st,
+			cancellationLock: storage.NewLocalLock(),
+			outbox:           st,
+			outboxLock:       func(string) locker { return storage.NewLocalLock() },
+			webhooks:         st,
+			webhooksLock:     storage.NewLocalLock(),
+		}, nil
+	}
+
+	gormDB, err := gorm.Open("postgres", config.GetPostgresConnection())
+	if err != nil {
+		return storages{}, err
+	}
+
+	if cfg.StrictSchema {
+		err = migrations.Check(gormDB.DB())
+	} else {
+		err = applyMigrations(gormDB.DB())
+	}
+	if err != nil {
+		return storages{}, err
+	}
+	return storages{
+		events:           storage.NewEvents(gormDB),
+		accounts:         storage.NewAccounts(gormDB),
+		runs:             storage.NewCancellationRuns(gormDB),
+		cancellationLock: storage.NewAdvisoryLock(gormDB, services.CancellationLockName),
+		outbox:           storage.NewOutbox(gormDB),
+		outboxLock:       func(consumer string) locker { return storage.NewAdvisoryLock(gormDB, outbox.LockName(consumer)) },
+		webhooks:         storage.NewWebhooks(gormDB),
+		webhooksLock:     storage.NewAdvisoryLock(gormDB, webhooks.LockName),
+		notifier:         storage.NewNotifier(gormDB, stream.Channel),
+		listener:         storage.NewListener(config.GetPostgresConnection(), stream.Channel),
+	}, nil
+}
+
+// newPublisher returns publisher of outbox messages chosen by config
+func newPublisher(cfg config.OutboxConfig) (outbox.Publisher, error) {
+	switch cfg.Publisher {
+	case config.OutboxStdout:
+		return outbox.NewStreamPublisher(os.Stdout), nil
+	case config.OutboxFile:
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
 		}
+		return outbox.NewStreamPublisher(f), nil
+	case config.OutboxHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("outbox url is required by %q publisher", cfg.Publisher)
+		}
+		client := &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Millisecond}
+		return outbox.NewHTTPPublisher(cfg.URL, client), nil
 	}
+	return nil, fmt.Errorf("unknown outbox publisher %q", cfg.Publisher)
 }
 
 func applyMigrations(db *sql.DB) error {
-	migrations := &migrate.FileMigrationSource{
-		Dir: "migrations",
-	}
-	n, err := migrate.Exec(db, "postgres", migrations, migrate.Up)
+	n, err := migrations.Up(db)
 	if err != nil {
 		return err
 	}
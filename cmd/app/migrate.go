@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/migrations"
+)
+
+const migrateUsage = "Usage: app migrate up [n] | down [n] | status | redo"
+
+// migrateCommand manages database schema, args follow "migrate"
+func migrateCommand(cfg config.Config, args []string) error {
+	if cfg.Storage == config.StorageMemory {
+		return errors.New("In-memory storage has no schema to migrate")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	db, err := sql.Open("postgres", config.GetPostgresConnection())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		n, err := migrationsLimit(args[1:], 0)
+		if err != nil {
+			return err
+		}
+		n, err = migrations.UpMax(db, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+	case "down":
+		n, err := migrationsLimit(args[1:], 1)
+		if err != nil {
+			return err
+		}
+		n, err = migrations.Down(db, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migrations\n", n)
+	case "redo":
+		if err := migrations.Redo(db); err != nil {
+			return err
+		}
+		fmt.Println("Rolled back and applied the last migration again")
+	case "status":
+		statuses, err := migrations.Statuses(db)
+		if err != nil {
+			return err
+		}
+		printStatuses(statuses)
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
+
+// migrationsLimit parses optional number of migrations, zero means all
+func migrationsLimit(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, errors.New(migrateUsage)
+	}
+	return n, nil
+}
+
+func printStatuses(statuses []migrations.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED")
+	for _, s := range statuses {
+		applied := "no"
+		if !s.AppliedAt.IsZero() {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		if s.Unknown {
+			applied += " (unknown to this app)"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", s.ID, applied)
+	}
+	w.Flush()
+}
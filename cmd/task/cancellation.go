@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"syscall"
 	"time"
 
+	"github.com/djumpen/test-ex-go/cancellation"
 	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/scheduler"
 	"github.com/djumpen/test-ex-go/services"
+	"github.com/djumpen/test-ex-go/sources"
 	"github.com/djumpen/test-ex-go/storage"
 	"github.com/jinzhu/gorm"
 	_ "github.com/lib/pq"
@@ -14,23 +20,64 @@ import (
 func main() {
 	cfg := config.GetConfig()
 
+	if cfg.Storage == config.StorageMemory {
+		log.Fatal("Cancellation task can't reach in-memory storage of another process")
+	}
+
 	gormDB, err := gorm.Open("postgres", config.GetPostgresConnection())
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	strategy, err := cancellation.FromConfig(cfg.Cancellation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := cancellation.Options{Partial: cfg.Cancellation.Partial}
+	log.Printf("Cancellation strategy %s %v, partial %t", strategy.Name(), strategy.Params(), opts.Partial)
+
+	sourceTypes, err := sources.NewRegistry(cfg.SourceTypes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	eventsStorage := storage.NewEvents(gormDB)
-	eventsSvc := services.NewEvents(eventsStorage)
+	eventsSvc := services.NewEvents(eventsStorage, storage.NewCancellationRuns(gormDB),
+		storage.NewAdvisoryLock(gormDB, services.CancellationLockName), sourceTypes)
+	timeout := time.Duration(cfg.CancellationTimeout) * time.Second
 
-	numCancelRecords := 10
 	if cfg.CancellationSelfRepeat {
-		eventsSvc.RepeatCancellationTask(time.Duration(cfg.RepeatCancellationEvery)*time.Minute, numCancelRecords)
-		exit := make(chan struct{})
-		<-exit
+		schedule, err := scheduler.Parse(cfg.CancellationScheduleSpec())
+		if err != nil {
+			log.Fatal(err)
+		}
+		sched := scheduler.New()
+		sched.Add(scheduler.Job{
+			Name:     "cancellation",
+			Schedule: schedule,
+			Jitter:   time.Duration(cfg.CancellationJitter) * time.Second,
+			Timeout:  timeout,
+			Run: func(ctx context.Context) error {
+				return eventsSvc.ExecCancellation(ctx, strategy, opts)
+			},
+		})
+		// run in progress is finished before exit
+		ctx, stop := scheduler.StopOnSignals(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		sched.Run(ctx)
 	} else {
-		err := eventsSvc.ExecCancellation(numCancelRecords)
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		err := eventsSvc.ExecCancellation(ctx, strategy, opts)
 		if err != nil {
-			log.Print(err) // TODO: error logging
+			log.Print(err) // details are kept in run history
 		}
 	}
+	if err := eventsSvc.ReleaseCancellation(context.Background()); err != nil {
+		log.Print(err)
+	}
 }
@@ -2,24 +2,146 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"runtime"
+	"strings"
+	"time"
 
 	"path/filepath"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 var cfg *Config
 
+const (
+	OutboxStdout = "stdout"
+	OutboxFile   = "file"
+	OutboxHTTP   = "http"
+)
+
+const (
+	StoragePostgres = "postgres"
+	// StorageMemory keeps everything in process memory, for local development only
+	StorageMemory = "memory"
+)
+
 type (
 	Config struct {
 		ReleaseMode             bool
+		Storage                 string     `json:"storage"`
 		Postgres                PsqlConfig `json:"postgres"`
 		Port                    int        `json:"port"`
 		CertFile                string     `json:"certFile"`
 		KeyFile                 string     `json:"keyFile"`
 		RepeatCancellationEvery int        `json:"repeatCancellationEvery"`
 		CancellationSelfRepeat  bool       `json:"cancellationSelfRepeat"`
+		// CancellationSchedule is cron expression like "*/10 * * * *" or "@every 10m", overrides RepeatCancellationEvery
+		CancellationSchedule string `json:"cancellationSchedule"`
+		// CancellationJitter delays every scheduled run by random time up to it, in seconds
+		CancellationJitter int `json:"cancellationJitter"`
+		// AppCancellation runs scheduled cancellation inside the app instead of separate task
+		AppCancellation bool `json:"appCancellation"`
+		// CancellationTimeout limits single cancellation run, in seconds
+		CancellationTimeout int `json:"cancellationTimeout"`
+		// Cancellation chooses events voided by cancellation task
+		Cancellation CancellationConfig `json:"cancellation"`
+		// RequestTimeout is default deadline of API request, in milliseconds
+		RequestTimeout int `json:"requestTimeout"`
+		// RouteTimeouts overrides RequestTimeout for routes like "POST /event"
+		RouteTimeouts map[string]int `json:"routeTimeouts"`
+		// StrictSchema refuses to start unless database schema matches migrations built into the app,
+		// otherwise pending migrations are applied on start
+		StrictSchema bool `json:"strictSchema"`
+		// SourceTypes holds settings of every source type by its name,
+		// they are reloaded on config file change
+		SourceTypes map[string]SourceTypeConfig `json:"sourceTypes"`
+		// Outbox publishes changes of events and balances to other systems
+		Outbox OutboxConfig `json:"outbox"`
+		// Webhooks delivers event notifications to endpoints registered through admin API
+		Webhooks WebhooksConfig `json:"webhooks"`
+		// AdminTokens are accepted in "Authorization: Bearer <token>" header by admin API, empty disables admin API
+		AdminTokens []string `json:"adminTokens"`
+		// ReadTokens are accepted in "Authorization: Bearer <token>" header by event and balance lookups along with AdminTokens
+		ReadTokens []string `json:"readTokens"`
+		// Stream pushes changes to clients of GET /stream
+		Stream StreamConfig `json:"stream"`
+	}
+
+	StreamConfig struct {
+		Enabled bool `json:"enabled"`
+		// BufferSize is number of the latest messages kept for reconnecting clients to resume from
+		BufferSize int `json:"bufferSize"`
+		// Heartbeat is interval of comments keeping idle streams open, in seconds
+		Heartbeat int `json:"heartbeat"`
+		// Tokens are accepted in "Authorization: Bearer <token>" header or access_token query parameter,
+		// empty rejects every client
+		Tokens []string `json:"tokens"`
+	}
+
+	WebhooksConfig struct {
+		// Enabled queues deliveries from outbox and dispatches them
+		Enabled bool `json:"enabled"`
+		// Schedule is cron expression of dispatcher runs, like "@every 1s"
+		Schedule string `json:"schedule"`
+		// BatchSize limits deliveries sent to an endpoint in one run
+		BatchSize int `json:"batchSize"`
+		// MaxAttempts failed in a row move delivery to dead letters
+		MaxAttempts int `json:"maxAttempts"`
+		// DisableAfter attempts to the endpoint failed in a row disable it, zero never does
+		DisableAfter int `json:"disableAfter"`
+		// Backoff is delay after the first failed attempt like "10s", doubled after every next one up to MaxBackoff
+		Backoff    time.Duration `json:"backoff"`
+		MaxBackoff time.Duration `json:"maxBackoff"`
+		// Timeout limits single delivery, in milliseconds
+		Timeout int `json:"timeout"`
+	}
+
+	OutboxConfig struct {
+		// Publisher is one of "stdout", "file", "http", empty disables publishing
+		Publisher string `json:"publisher"`
+		// File is path messages are appended to by "file" publisher
+		File string `json:"file"`
+		// URL receives messages posted by "http" publisher
+		URL string `json:"url"`
+		// Schedule is cron expression of relay runs, like "@every 1s"
+		Schedule string `json:"schedule"`
+		// BatchSize limits messages read at once
+		BatchSize int `json:"batchSize"`
+		// Timeout limits single delivery by "http" publisher, in milliseconds
+		Timeout int `json:"timeout"`
+	}
+
+	SourceTypeConfig struct {
+		DisplayName string `json:"displayName"`
+		// Enabled source types are accepted, missing flag means enabled
+		Enabled *bool `json:"enabled"`
+		// Credentials are tokens the source sends in Source-Token header, empty means no token required
+		Credentials []string `json:"credentials"`
+		// States allowed for the source like ["WIN"], empty allows all
+		States []string `json:"states"`
+		// MinAmount and MaxAmount limit absolute event amount like "1000.00", empty means no limit
+		MinAmount string `json:"minAmount"`
+		MaxAmount string `json:"maxAmount"`
+		// LateWindow rejects events which occurred longer ago, like "1h", zero accepts any
+		LateWindow time.Duration `json:"lateWindow"`
+	}
+
+	CancellationConfig struct {
+		// Strategy is one of "odd-rows", "last", "older-than", "source-type", "state"
+		Strategy string `json:"strategy"`
+		// Number limits events canceled per wallet in one run
+		Number int `json:"number"`
+		// OlderThan is event age like "24h" for "older-than" strategy
+		OlderThan  time.Duration `json:"olderThan"`
+		SourceType string        `json:"sourceType"`
+		State      string        `json:"state"`
+		// Partial cancels the events which keep balance non-negative, newest first,
+		// instead of failing the whole run
+		Partial bool `json:"partial"`
+		// OrderBy is "ingestion" (default) or "occurrence", the time which orders events and limits their age
+		OrderBy string `json:"orderBy"`
 	}
 
 	PsqlConfig struct {
@@ -35,6 +157,23 @@ func GetConfig() Config {
 	return *cfg
 }
 
+// RouteTimeout returns request deadline for the route, zero means no deadline
+func (c Config) RouteTimeout(method, path string) time.Duration {
+	// viper keeps keys in lower case
+	if ms, ok := c.RouteTimeouts[strings.ToLower(method+" "+path)]; ok {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return time.Duration(c.RequestTimeout) * time.Millisecond
+}
+
+// CancellationScheduleSpec returns cron expression of cancellation schedule
+func (c Config) CancellationScheduleSpec() string {
+	if c.CancellationSchedule != "" {
+		return c.CancellationSchedule
+	}
+	return fmt.Sprintf("@every %dm", c.RepeatCancellationEvery)
+}
+
 func GetPostgresConnection() string {
 	return fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d sslmode=disable",
 		cfg.Postgres.Username,
@@ -46,20 +185,43 @@ func GetPostgresConnection() string {
 }
 
 func init() {
-	var c Config
 	viper.SetConfigName("config")
 	viper.AddConfigPath(getConfigPath())
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(fmt.Sprintf("Fatal error config file: %s \n", err))
 	}
-	err = viper.Unmarshal(&c)
+	c, err := readConfig()
 	if err != nil {
 		panic(fmt.Sprintf("Fatal error config file: %s \n", err))
 	}
 	cfg = &c
 }
 
+func readConfig() (Config, error) {
+	var c Config
+	if err := viper.Unmarshal(&c); err != nil {
+		return c, err
+	}
+	// Unmarshal skips keys without settings like "game": {}, source type must stay defined
+	err := viper.UnmarshalKey("sourceTypes", &c.SourceTypes)
+	return c, err
+}
+
+// Watch calls onChange with config read again every time config file changes.
+// Config returned by GetConfig stays the same, so only settings applied by onChange are reloaded.
+func Watch(onChange func(Config)) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		c, err := readConfig()
+		if err != nil {
+			log.Printf("Config file %s changed but can't be read: %s", e.Name, err)
+			return
+		}
+		onChange(c)
+	})
+	viper.WatchConfig()
+}
+
 func getConfigPath() string {
 	_, filename, _, _ := runtime.Caller(0)
 	return filepath.Dir(filepath.Dir(filename))
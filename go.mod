@@ -2,7 +2,9 @@ module github.com/djumpen/test-ex-go
 
 require (
 	github.com/Microsoft/hcsshim v0.8.6 // indirect
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gin-contrib/cors v1.3.0
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/gin v1.5.0
 	github.com/google/uuid v1.1.1
 	github.com/jinzhu/gorm v1.9.11
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bearerPrefix starts Authorization header carrying a token
+	bearerPrefix = "Bearer "
+	// TokenQueryParam carries the token of stream clients which can't send headers, like browsers' EventSource
+	TokenQueryParam = "access_token"
+)
+
+var errInvalidToken = errors.New("Valid token required")
+
+// RequireToken lets through requests with one of the tokens in "Authorization: Bearer <token>" header,
+// no tokens reject every request
+func RequireToken(r Responder, tokens []string) gin.HandlerFunc {
+	return requireToken(r, tokens, false)
+}
+
+// RequireStreamToken is RequireToken also taking the token from access_token query parameter
+func RequireStreamToken(r Responder, tokens []string) gin.HandlerFunc {
+	return requireToken(r, tokens, true)
+}
+
+func requireToken(r Responder, tokens []string, inQuery bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.GetHeader("Authorization")
+		token, ok := strings.TrimPrefix(h, bearerPrefix), strings.HasPrefix(h, bearerPrefix)
+		if !ok && inQuery {
+			token, ok = c.GetQuery(TokenQueryParam)
+		}
+		if !ok || !validToken(tokens, token) {
+			processError(c, apperrors.NewUnauthorized(errInvalidToken), r)
+			return
+		}
+	}
+}
+
+func validToken(tokens []string, token string) bool {
+	ok := false
+	for _, t := range tokens {
+		// compare every token to keep timing independent of the match
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusResponder answers errors with their status only
+type statusResponder struct{}
+
+func (statusResponder) BadRequest(c *gin.Context, _ string, _ error) {
+	c.AbortWithStatus(http.StatusBadRequest)
+}
+func (statusResponder) NotFound(c *gin.Context, _ error) { c.AbortWithStatus(http.StatusNotFound) }
+func (statusResponder) Unauthorized(c *gin.Context, _ error) {
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+func (statusResponder) ResponseErrWithFields(c *gin.Context, _ []string) {
+	c.AbortWithStatus(http.StatusUnprocessableEntity)
+}
+func (statusResponder) Conflict(c *gin.Context, _ []string) { c.AbortWithStatus(http.StatusConflict) }
+func (statusResponder) Timeout(c *gin.Context, _ error) {
+	c.AbortWithStatus(http.StatusGatewayTimeout)
+}
+func (statusResponder) InternalError(c *gin.Context, _ error) {
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// Only requests with a configured token reach admin handlers
+func TestRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name          string
+		tokens        []string
+		authorization string
+		status        int
+	}{
+		{"NoHeader", []string{"secret"}, "", http.StatusUnauthorized},
+		{"WrongToken", []string{"secret"}, "Bearer guess", http.StatusUnauthorized},
+		{"NotBearer", []string{"secret"}, "secret", http.StatusUnauthorized},
+		{"NoTokensConfigured", nil, "Bearer ", http.StatusUnauthorized},
+		{"EmptyToken", []string{""}, "Bearer ", http.StatusUnauthorized},
+		{"ValidToken", []string{"old", "secret"}, "Bearer secret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			admin := r.Group("/admin", RequireToken(statusResponder{}, tc.tokens))
+			admin.POST("/webhooks", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/admin/webhooks", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+// Stream clients may send the token in query, admin API takes it from the header only
+func TestRequireStreamToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name          string
+		require       func(Responder, []string) gin.HandlerFunc
+		target        string
+		authorization string
+		status        int
+	}{
+		{"NoToken", RequireStreamToken, "/stream", "", http.StatusUnauthorized},
+		{"WrongQueryToken", RequireStreamToken, "/stream?access_token=guess", "", http.StatusUnauthorized},
+		{"QueryToken", RequireStreamToken, "/stream?accountId=1&access_token=secret", "", http.StatusOK},
+		{"HeaderToken", RequireStreamToken, "/stream", "Bearer secret", http.StatusOK},
+		{"WrongHeaderWins", RequireStreamToken, "/stream?access_token=secret", "Bearer guess", http.StatusUnauthorized},
+		{"AdminQueryToken", RequireToken, "/stream?access_token=secret", "", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/stream", tc.require(statusResponder{}, []string{"secret"}), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deadline limits request processing time with per-route timeout.
+// Storage aborts and rolls back its transactions once the deadline is exceeded
+// or the client disconnects.
+func Deadline(timeout func(method, path string) time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := timeout(c.Request.Method, c.FullPath())
+		if d <= 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -1,9 +1,12 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/djumpen/test-ex-go/apperrors"
 	"github.com/gin-gonic/gin"
@@ -14,7 +17,10 @@ import (
 type Responder interface {
 	BadRequest(c *gin.Context, description string, err error)
 	NotFound(c *gin.Context, err error)
+	Unauthorized(c *gin.Context, err error)
 	ResponseErrWithFields(c *gin.Context, fields []string)
+	Conflict(c *gin.Context, fields []string)
+	Timeout(c *gin.Context, err error)
 	InternalError(c *gin.Context, err error)
 }
 
@@ -41,24 +47,57 @@ func processError(c *gin.Context, err error, r Responder) {
 
 	log.Printf("ERROR: %v", err)
 
+	switch errors.Cause(err) {
+	case context.DeadlineExceeded:
+		r.Timeout(c, err)
+		return
+	case context.Canceled:
+		// client has gone, nobody to respond to
+		c.Abort()
+		return
+	}
+
+	switch ve := errors.Cause(err).(type) {
+	case validator.ValidationErrors, *apperrors.Validation, *json.UnmarshalTypeError:
+		r.ResponseErrWithFields(c, ErrorMessages(ve))
+	case *apperrors.BadRequest:
+		r.BadRequest(c, ve.Error(), ve)
+	case *apperrors.NotFound:
+		r.NotFound(c, ve)
+	case *apperrors.Unauthorized:
+		r.Unauthorized(c, ve)
+	case *strconv.NumError:
+		r.ResponseErrWithFields(c, []string{fmt.Sprintf("'%s' is not a valid number", ve.Num)})
+	case *time.ParseError:
+		r.ResponseErrWithFields(c, []string{fmt.Sprintf("'%s' is not a valid time", ve.Value)})
+	case *apperrors.Conflict:
+		r.Conflict(c, ErrorMessages(ve))
+	default:
+		r.InternalError(c, err)
+	}
+}
+
+// ErrorMessages explains error of the client the way error responses do
+func ErrorMessages(err error) []string {
 	switch ve := errors.Cause(err).(type) {
 	case validator.ValidationErrors:
 		fields := make([]string, 0, len(ve))
 		for _, v := range ve {
 			fields = append(fields, validationErrorToText(v))
 		}
-		r.ResponseErrWithFields(c, fields)
-	case *apperrors.Validation:
-		r.ResponseErrWithFields(c, []string{ve.Error()})
+		return fields
 	case *json.UnmarshalTypeError:
-		err := err.(*json.UnmarshalTypeError)
-		validationError := unmarshalTypeErrorToValidation(err)
-		r.ResponseErrWithFields(c, []string{validationError})
-	case *apperrors.BadRequest:
-		r.BadRequest(c, ve.Error(), ve)
-	default:
-		r.InternalError(c, err)
+		return []string{unmarshalTypeErrorToValidation(ve)}
+	case *apperrors.Conflict:
+		fields := []string{ve.Error()}
+		for _, f := range ve.Fields() {
+			fields = append(fields, fmt.Sprintf("%s differs from the stored one", split(f)))
+		}
+		return fields
+	case nil:
+		return nil
 	}
+	return []string{errors.Cause(err).Error()}
 }
 
 func validationErrorToText(e validator.FieldError) string {
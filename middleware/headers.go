@@ -2,18 +2,25 @@ package middleware
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/sources"
 	"github.com/gin-gonic/gin"
 )
 
-var sourceTypes = []string{"game", "server", "payment"}
+const (
+	SourceTypeHeader = "Source-Type"
+	// SourceTokenHeader carries credentials of source types which require them
+	SourceTokenHeader = "Source-Token"
+)
 
-const SourceTypeHeader = "Source-Type"
+type sourceRegistry interface {
+	Lookup(name string) (sources.Source, bool)
+}
 
 // ValidateSourceType ensures that request contains valid Source-Type header
-func ValidateSourceType(r Responder) gin.HandlerFunc {
+// of enabled source type and its credentials when required
+func ValidateSourceType(r Responder, registry sourceRegistry) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		st := c.GetHeader(SourceTypeHeader)
 		if len(st) == 0 {
@@ -21,10 +28,16 @@ func ValidateSourceType(r Responder) gin.HandlerFunc {
 			processError(c, err, r)
 			return
 		}
-		if !stringInSlice(strings.ToLower(st), sourceTypes) {
+		source, ok := registry.Lookup(st)
+		if !ok || !source.Enabled {
 			err := apperrors.NewBadRequest(fmt.Errorf("Unsupported %s header", SourceTypeHeader))
 			processError(c, err, r)
 			return
 		}
+		if !source.Authorize(c.GetHeader(SourceTokenHeader)) {
+			err := apperrors.NewUnauthorized(fmt.Errorf("Invalid %s header", SourceTokenHeader))
+			processError(c, err, r)
+			return
+		}
 	}
 }
@@ -0,0 +1,12 @@
+-- +migrate Up
+-- occurrence time of events created before sources reported it is unknown, ingestion time stands for it
+alter table events add occurred_at timestamp;
+update events set occurred_at = created_at;
+alter table events alter column occurred_at set not null;
+
+create index events_occurred_at_index
+	on events (occurred_at);
+
+-- +migrate Down
+drop index events_occurred_at_index;
+alter table events drop column occurred_at;
@@ -0,0 +1,23 @@
+-- +migrate Up
+-- messages about changes written in the same transaction, relayed to other systems in id order
+create table outbox
+(
+	id bigserial not null
+		constraint outbox_pk
+			primary key,
+	-- dedup key, the longest one is transaction id with topic prefix
+	key varchar(192) not null,
+	topic varchar(32) not null,
+	payload jsonb not null,
+	created_at timestamp not null,
+	published_at timestamp
+);
+
+create unique index outbox_key_uindex
+	on outbox (key);
+
+create index outbox_pending_index
+	on outbox (id) where published_at is null;
+
+-- +migrate Down
+drop table outbox;
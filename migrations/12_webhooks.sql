@@ -0,0 +1,67 @@
+-- +migrate Up
+create table webhook_endpoints
+(
+	id serial not null
+		constraint webhook_endpoints_pk
+			primary key,
+	url text not null,
+	secret varchar(128) not null,
+	-- filters, empty array matches any event
+	states jsonb not null default '[]',
+	statuses jsonb not null default '[]',
+	source_types jsonb not null default '[]',
+	created_at timestamp not null
+);
+
+-- notifications queued from outbox messages, one per matching endpoint
+create table webhook_deliveries
+(
+	id bigserial not null
+		constraint webhook_deliveries_pk
+			primary key,
+	endpoint_id integer not null
+		constraint webhook_deliveries_endpoints_id_fk
+			references webhook_endpoints
+				on delete cascade,
+	key varchar(192) not null,
+	topic varchar(32) not null,
+	payload jsonb not null,
+	created_at timestamp not null,
+	attempts integer not null default 0,
+	next_attempt_at timestamp not null,
+	last_error text not null default '',
+	delivered_at timestamp
+);
+
+create unique index webhook_deliveries_endpoint_key_uindex
+	on webhook_deliveries (endpoint_id, key);
+
+create index webhook_deliveries_due_index
+	on webhook_deliveries (next_attempt_at) where delivered_at is null;
+
+-- deliveries which failed every attempt, moved back to deliveries on replay
+create table webhook_dead_letters
+(
+	id bigint not null
+		constraint webhook_dead_letters_pk
+			primary key,
+	endpoint_id integer not null
+		constraint webhook_dead_letters_endpoints_id_fk
+			references webhook_endpoints
+				on delete cascade,
+	key varchar(192) not null,
+	topic varchar(32) not null,
+	payload jsonb not null,
+	created_at timestamp not null,
+	attempts integer not null,
+	last_error text not null default '',
+	failed_at timestamp not null
+);
+
+create index webhook_dead_letters_endpoint_id_index
+	on webhook_dead_letters (endpoint_id);
+
+-- +migrate Down
+drop table webhook_dead_letters;
+drop table webhook_deliveries;
+drop table webhook_endpoints;
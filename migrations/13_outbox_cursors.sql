@@ -0,0 +1,27 @@
+-- +migrate Up
+-- every consumer of the outbox is relayed on its own up to the last message it published
+create table outbox_cursors
+(
+	consumer varchar(32) not null
+		constraint outbox_cursors_pk
+			primary key,
+	last_id bigint not null
+);
+
+-- consumers carry on from the first message none of them published
+insert into outbox_cursors(consumer, last_id)
+select c.consumer, coalesce((select min(id) - 1 from outbox where published_at is null), (select max(id) from outbox), 0)
+from (values ('publisher'), ('webhooks'), ('stream')) as c(consumer);
+
+drop index outbox_pending_index;
+alter table outbox drop column published_at;
+
+-- +migrate Down
+alter table outbox add published_at timestamp;
+-- a message is published once every consumer has published it
+update outbox set published_at = now()
+where id <= (select min(last_id) from outbox_cursors);
+create index outbox_pending_index
+	on outbox (id) where published_at is null;
+
+drop table outbox_cursors;
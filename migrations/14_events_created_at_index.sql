@@ -0,0 +1,8 @@
+-- +migrate Up
+-- events list is filtered and sorted by creation time;
+-- databases migrated before the index got its own migration already have it
+create index if not exists events_created_at_index
+	on events (created_at);
+
+-- +migrate Down
+drop index events_created_at_index;
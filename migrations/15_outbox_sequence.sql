@@ -0,0 +1,18 @@
+-- +migrate Up
+-- relays number committed messages, so writers don't wait for each other to keep ids in commit order
+alter table outbox add seq bigint;
+update outbox set seq = id;
+
+create unique index outbox_seq_uindex
+	on outbox (seq);
+
+create index outbox_unsequenced_index
+	on outbox (id) where seq is null;
+
+-- +migrate Down
+-- consumers carry on from the first message they may have missed by id
+update outbox_cursors c
+set last_id = coalesce((select min(id) - 1 from outbox where seq is null or seq > c.last_id), (select max(id) from outbox), 0);
+
+drop index outbox_unsequenced_index;
+alter table outbox drop column seq;
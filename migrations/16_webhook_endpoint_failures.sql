@@ -0,0 +1,10 @@
+-- +migrate Up
+-- endpoints failing too many attempts in a row are disabled till enabled through admin API
+alter table webhook_endpoints
+	add failures integer not null default 0,
+	add disabled_at timestamp;
+
+-- +migrate Down
+alter table webhook_endpoints
+	drop column disabled_at,
+	drop column failures;
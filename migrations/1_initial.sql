@@ -28,4 +28,10 @@ create table balance
  	updated_at timestamp default now() not null
  );
 
- INSERT INTO balance(total) VALUES(0);
\ No newline at end of file
+ INSERT INTO balance(total) VALUES(0);
+
+-- +migrate Down
+drop table balance;
+drop table events;
+DROP TYPE status;
+DROP TYPE state;
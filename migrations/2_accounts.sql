@@ -0,0 +1,41 @@
+-- +migrate Up
+create table accounts
+(
+	id serial not null
+		constraint accounts_pk
+			primary key,
+	created_at timestamp default now() not null
+);
+
+-- account #1 takes over the former global balance and all existing events
+INSERT INTO accounts DEFAULT VALUES;
+
+alter table balance
+	add account_id integer
+		constraint balance_accounts_id_fk
+			references accounts;
+
+update balance set account_id = 1 where id = 1;
+
+alter table balance alter column account_id set not null;
+
+create unique index balance_account_id_uindex
+	on balance (account_id);
+
+alter table events
+	add account_id integer
+		constraint events_accounts_id_fk
+			references accounts;
+
+update events set account_id = 1;
+
+alter table events alter column account_id set not null;
+
+create index events_account_id_index
+	on events (account_id, id);
+
+-- +migrate Down
+alter table events drop column account_id;
+delete from balance where account_id <> 1;
+alter table balance drop column account_id;
+drop table accounts;
@@ -0,0 +1,15 @@
+-- +migrate Up
+alter table events alter column amount type numeric(18,4) using round(amount::numeric, 4);
+alter table balance alter column total type numeric(18,4) using round(total::numeric, 4);
+
+-- get rid of float drift accumulated so far
+update balance b
+set total = coalesce((
+	select sum(e.amount)
+	from events e
+	where e.account_id = b.account_id and e.status = 'PROCESSED'
+), 0);
+
+-- +migrate Down
+alter table events alter column amount type float using amount::float;
+alter table balance alter column total type float using total::float;
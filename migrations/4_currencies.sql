@@ -0,0 +1,30 @@
+-- +migrate Up
+-- everything created before currencies were introduced is treated as EUR
+alter table events add currency char(3);
+update events set currency = 'EUR';
+alter table events alter column currency set not null;
+
+drop index events_account_id_index;
+create index events_account_id_currency_index
+	on events (account_id, currency, id);
+
+alter table balance add currency char(3);
+update balance set currency = 'EUR';
+alter table balance alter column currency set not null;
+
+drop index balance_account_id_uindex;
+create unique index balance_account_id_currency_uindex
+	on balance (account_id, currency);
+
+-- +migrate Down
+drop index balance_account_id_currency_uindex;
+delete from balance where currency <> 'EUR';
+alter table balance drop column currency;
+create unique index balance_account_id_uindex
+	on balance (account_id);
+
+drop index events_account_id_currency_index;
+delete from events where currency <> 'EUR';
+alter table events drop column currency;
+create index events_account_id_index
+	on events (account_id, id);
@@ -0,0 +1,98 @@
+-- +migrate Up
+CREATE TYPE ledger_book AS ENUM ('WALLET', 'HOUSE');
+CREATE TYPE ledger_side AS ENUM ('DEBIT', 'CREDIT');
+
+create table ledger_entries
+(
+	id bigserial not null
+		constraint ledger_entries_pk
+			primary key,
+	journal_id uuid not null,
+	event_id integer not null
+		constraint ledger_entries_events_id_fk
+			references events,
+	account_id integer not null
+		constraint ledger_entries_accounts_id_fk
+			references accounts,
+	currency char(3) not null,
+	book ledger_book not null,
+	side ledger_side not null,
+	amount numeric(18,4) not null
+		constraint ledger_entries_amount_check
+			check (amount > 0),
+	created_at timestamp default now() not null
+);
+
+create index ledger_entries_wallet_index
+	on ledger_entries (account_id, currency, book);
+
+create index ledger_entries_journal_id_index
+	on ledger_entries (journal_id);
+
+create index ledger_entries_event_id_index
+	on ledger_entries (event_id);
+
+-- +migrate StatementBegin
+CREATE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER ledger_entries_append_only
+	BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE PROCEDURE ledger_entries_append_only();
+
+-- every journal must have equal debits and credits in each currency by the end of transaction
+-- +migrate StatementBegin
+CREATE FUNCTION ledger_entries_balanced() RETURNS trigger AS $$
+BEGIN
+	IF EXISTS (
+		SELECT 1 FROM ledger_entries
+		WHERE journal_id = NEW.journal_id
+		GROUP BY currency
+		HAVING sum(CASE side WHEN 'DEBIT' THEN amount ELSE -amount END) <> 0
+	) THEN
+		RAISE EXCEPTION 'ledger journal % is not balanced', NEW.journal_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+	AFTER INSERT ON ledger_entries
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE PROCEDURE ledger_entries_balanced();
+
+-- journal for every existing event
+INSERT INTO ledger_entries(journal_id, event_id, account_id, currency, book, side, amount, created_at)
+SELECT md5('event:' || e.id)::uuid, e.id, e.account_id, e.currency, p.book::ledger_book,
+	(CASE WHEN (e.amount > 0) = (p.book = 'WALLET') THEN 'CREDIT' ELSE 'DEBIT' END)::ledger_side,
+	abs(e.amount), e.created_at
+FROM events e, (VALUES ('WALLET'), ('HOUSE')) p(book)
+WHERE e.amount <> 0;
+
+-- and reversing journal for already canceled ones
+INSERT INTO ledger_entries(journal_id, event_id, account_id, currency, book, side, amount, created_at)
+SELECT md5('cancel:' || e.id)::uuid, e.id, e.account_id, e.currency, p.book::ledger_book,
+	(CASE WHEN (e.amount > 0) = (p.book = 'WALLET') THEN 'DEBIT' ELSE 'CREDIT' END)::ledger_side,
+	abs(e.amount), coalesce(e.updated_at, e.created_at)
+FROM events e, (VALUES ('WALLET'), ('HOUSE')) p(book)
+WHERE e.amount <> 0 AND e.status = 'CANCELED';
+
+-- balance becomes projection of the ledger
+update balance b
+set total = coalesce((
+	select sum(CASE l.side WHEN 'CREDIT' THEN l.amount ELSE -l.amount END)
+	from ledger_entries l
+	where l.account_id = b.account_id and l.currency = b.currency and l.book = 'WALLET'
+), 0);
+
+-- +migrate Down
+drop table ledger_entries;
+DROP FUNCTION ledger_entries_append_only();
+DROP FUNCTION ledger_entries_balanced();
+DROP TYPE ledger_side;
+DROP TYPE ledger_book;
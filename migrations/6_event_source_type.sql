@@ -0,0 +1,6 @@
+-- +migrate Up
+-- source type of events created before it was stored is unknown (empty)
+alter table events add source_type varchar(32) not null default '';
+
+-- +migrate Down
+alter table events drop column source_type;
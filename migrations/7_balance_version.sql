@@ -0,0 +1,5 @@
+-- +migrate Up
+alter table balance add version bigint not null default 0;
+
+-- +migrate Down
+alter table balance drop column version;
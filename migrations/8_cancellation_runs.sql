@@ -0,0 +1,48 @@
+-- +migrate Up
+create table cancellation_runs
+(
+	id serial not null
+		constraint cancellation_runs_pk
+			primary key,
+	strategy varchar(32) not null,
+	params jsonb not null default '{}',
+	partial boolean not null default false,
+	started_at timestamp not null,
+	finished_at timestamp,
+	error text not null default ''
+);
+
+create index cancellation_runs_started_at_index
+	on cancellation_runs (started_at);
+
+-- outcome of the run in every wallet it went through
+create table cancellation_run_wallets
+(
+	run_id integer not null
+		constraint cancellation_run_wallets_runs_id_fk
+			references cancellation_runs,
+	account_id integer not null
+		constraint cancellation_run_wallets_accounts_id_fk
+			references accounts,
+	currency char(3) not null,
+	event_ids jsonb not null default '[]',
+	skipped_ids jsonb not null default '[]',
+	balance_before numeric(18,4) not null,
+	balance_after numeric(18,4) not null,
+	error text not null default '',
+	constraint cancellation_run_wallets_pk
+		primary key (run_id, account_id, currency)
+);
+
+alter table events
+	add cancellation_run_id integer
+		constraint events_cancellation_runs_id_fk
+			references cancellation_runs;
+
+create index events_cancellation_run_id_index
+	on events (cancellation_run_id);
+
+-- +migrate Down
+alter table events drop column cancellation_run_id;
+drop table cancellation_run_wallets;
+drop table cancellation_runs;
@@ -0,0 +1,10 @@
+-- +migrate Up
+alter table events add metadata jsonb;
+
+-- serves containment queries on any metadata key
+create index events_metadata_index
+	on events using gin (metadata jsonb_path_ops);
+
+-- +migrate Down
+drop index events_metadata_index;
+alter table events drop column metadata;
@@ -0,0 +1,50 @@
+//go:build ignore
+// +build ignore
+
+// gen.go embeds migration files into the app, run by go generate
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	names, err := filepath.Glob("*.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen.go from *.sql files; DO NOT EDIT.\n\n")
+	b.WriteString("package migrations\n\n")
+	b.WriteString("var files = map[string]string{\n")
+	for _, name := range names {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&b, "%q: %s,\n", name, quote(string(content)))
+	}
+	b.WriteString("}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("files.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// quote keeps SQL readable as raw string when possible
+func quote(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
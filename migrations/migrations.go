@@ -0,0 +1,120 @@
+// Package migrations keeps database schema migrations built into the app.
+// Migrations are written in *.sql files, files.go embeds them and must be regenerated after any change.
+package migrations
+
+//go:generate go run gen.go
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const dialect = "postgres"
+
+// Source returns migrations built into the app
+func Source() migrate.MigrationSource {
+	return migrate.AssetMigrationSource{
+		Asset: func(path string) ([]byte, error) {
+			content, ok := files[path]
+			if !ok {
+				return nil, errors.Errorf("Migration %s not found", path)
+			}
+			return []byte(content), nil
+		},
+		AssetDir: func(string) ([]string, error) {
+			names := make([]string, 0, len(files))
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names, nil
+		},
+	}
+}
+
+// Up applies all pending migrations and returns their number
+func Up(db *sql.DB) (int, error) {
+	return UpMax(db, 0)
+}
+
+// UpMax applies at most n pending migrations, all of them if n is zero
+func UpMax(db *sql.DB, n int) (int, error) {
+	n, err := migrate.ExecMax(db, dialect, Source(), migrate.Up, n)
+	return n, errors.Wrap(err, "Can't apply migrations")
+}
+
+// Down rolls back the last n applied migrations, all of them if n is zero
+func Down(db *sql.DB, n int) (int, error) {
+	n, err := migrate.ExecMax(db, dialect, Source(), migrate.Down, n)
+	return n, errors.Wrap(err, "Can't roll back migrations")
+}
+
+// Redo rolls back the last applied migration and applies it again
+func Redo(db *sql.DB) error {
+	n, err := Down(db, 1)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("No migration to redo")
+	}
+	_, err = UpMax(db, 1)
+	return err
+}
+
+// Status is state of one migration
+type Status struct {
+	ID string
+	// AppliedAt is zero for pending migrations
+	AppliedAt time.Time
+	// Unknown migration is applied to database but isn't built into the app
+	Unknown bool
+}
+
+// Statuses returns state of every migration known to the app or applied to database, in order
+func Statuses(db *sql.DB) ([]Status, error) {
+	known, err := Source().FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read migrations")
+	}
+	records, err := migrate.GetMigrationRecords(db, dialect)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read applied migrations")
+	}
+	applied := make(map[string]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Id] = r.AppliedAt
+	}
+	statuses := make([]Status, 0, len(known))
+	for _, m := range known {
+		statuses = append(statuses, Status{ID: m.Id, AppliedAt: applied[m.Id]})
+		delete(applied, m.Id)
+	}
+	for _, r := range records {
+		if _, ok := applied[r.Id]; ok {
+			statuses = append(statuses, Status{ID: r.Id, AppliedAt: r.AppliedAt, Unknown: true})
+		}
+	}
+	return statuses, nil
+}
+
+// Check returns error unless database schema matches migrations built into the app
+func Check(db *sql.DB) error {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.Unknown {
+			return errors.Errorf("Database schema is ahead of the app, migration %s is unknown", s.ID)
+		}
+		if s.AppliedAt.IsZero() {
+			return errors.Errorf("Database schema is behind the app, migration %s is not applied", s.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// files.go must be regenerated after any change of *.sql files
+func TestFilesGenerated(t *testing.T) {
+	a := assert.New(t)
+	names, err := filepath.Glob("*.sql")
+	if !a.NoError(err) {
+		return
+	}
+	a.Len(files, len(names), "run go generate ./migrations")
+	for _, name := range names {
+		content, err := ioutil.ReadFile(name)
+		a.NoError(err)
+		a.Equal(string(content), files[name], "run go generate ./migrations")
+	}
+}
+
+func TestEveryMigrationRollsBack(t *testing.T) {
+	a := assert.New(t)
+	migrations, err := Source().FindMigrations()
+	if !a.NoError(err) {
+		return
+	}
+	a.Len(migrations, len(files))
+	for i, m := range migrations {
+		a.NotEmpty(m.Up, m.Id)
+		a.NotEmpty(m.Down, m.Id)
+		if i > 0 {
+			a.True(migrations[i-1].Less(m), "%s goes after %s", m.Id, migrations[i-1].Id)
+		}
+	}
+	a.Equal("1_initial.sql", migrations[0].Id)
+}
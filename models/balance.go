@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Wallet is balance of one account in one currency
+type Wallet struct {
+	AccountID int
+	Currency  Currency
+}
+
+// Balance is current state of the wallet.
+// Version grows with every change of the total.
+type Balance struct {
+	Wallet
+	Total     Money
+	Version   int64
+	UpdatedAt time.Time
+}
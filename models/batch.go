@@ -0,0 +1,55 @@
+package models
+
+// BatchStatus is outcome of one event of a batch
+type BatchStatus string
+
+const (
+	BatchCreated BatchStatus = "created"
+	// BatchDuplicate is the event already stored with the same data
+	BatchDuplicate BatchStatus = "duplicate"
+	// BatchConflict is the transaction already stored with different data
+	BatchConflict            BatchStatus = "conflict"
+	BatchInvalid             BatchStatus = "validation_error"
+	BatchInsufficientBalance BatchStatus = "insufficient_balance"
+	// BatchAborted is the event of atomic batch not stored because another event failed
+	BatchAborted BatchStatus = "aborted"
+)
+
+// BatchResult is outcome of one event of a batch, Err explains failures
+type BatchResult struct {
+	Status BatchStatus
+	Err    error
+}
+
+// OK reports whether the event is stored
+func (r BatchResult) OK() bool {
+	return r.Status == BatchCreated || r.Status == BatchDuplicate
+}
+
+// BatchFailed reports whether any event of the batch failed
+func BatchFailed(results []BatchResult) bool {
+	for _, r := range results {
+		if r.Status != "" && !r.OK() {
+			return true
+		}
+	}
+	return false
+}
+
+// AbortBatch marks events which have no outcome yet or would be created as aborted,
+// for atomic batch with a failed event
+func AbortBatch(results []BatchResult) {
+	for i, r := range results {
+		if r.Status == "" || r.Status == BatchCreated {
+			results[i] = BatchResult{Status: BatchAborted}
+		}
+	}
+}
+
+// MergeBatch copies results of the events passed on to the next step back to their positions,
+// indexes[i] is position of the i-th of them
+func MergeBatch(results []BatchResult, indexes []int, next []BatchResult) {
+	for i, r := range next {
+		results[indexes[i]] = r
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrency(t *testing.T) {
+	a := assert.New(t)
+
+	c, err := ParseCurrency("eur")
+	a.NoError(err)
+	a.Equal(Currency("EUR"), c)
+	a.True(c.Fits(Money(101500)))
+	a.False(c.Fits(Money(101510)))
+
+	jpy, err := ParseCurrency("JPY")
+	a.NoError(err)
+	a.True(jpy.Fits(MoneyFromInt(100)))
+	a.False(jpy.Fits(Money(1005000)))
+
+	_, err = ParseCurrency("XXY")
+	a.Equal(ErrUnknownCurrency, err)
+}
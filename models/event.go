@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type EventStatus string
 type EventState string
 
@@ -11,10 +13,111 @@ const (
 	StateLoss EventState = "LOSS"
 )
 
+// EventTime chooses which time of event filters and orders events
+type EventTime string
+
+const (
+	// IngestionTime is when the event was received, it is used by default
+	IngestionTime EventTime = "ingestion"
+	// OccurrenceTime is when the event occurred according to its source
+	OccurrenceTime EventTime = "occurrence"
+)
+
 type Event struct {
 	ID            int
+	AccountID     int
 	State         EventState
-	Amount        float64
+	Amount        Money
+	Currency      Currency
 	TransactionID string
 	Status        EventStatus
+	SourceType    string
+	CreatedAt     time.Time
+	// OccurredAt is reported by the source, it equals CreatedAt if the source didn't report it
+	OccurredAt time.Time
+	// CancellationRunID is the run which canceled the event
+	CancellationRunID *int
+	Metadata          Metadata
+}
+
+// EventFilter selects events for listing, zero fields don't filter anything
+type EventFilter struct {
+	AccountID  int
+	Currency   Currency
+	State      EventState
+	Status     EventStatus
+	SourceType string
+	// Time chooses event time used by From, To and ordering, ingestion time by default
+	Time EventTime
+	// From and To limit event time to [From, To)
+	From time.Time
+	To   time.Time
+	// Metadata holds values required at dotted paths like "device.os"
+	Metadata map[string]string
+	// OldestFirst sorts events by time ascending instead of newest first
+	OldestFirst bool
+	Limit       int
+	Offset      int
+}
+
+// Match reports whether event passes the filter
+func (f EventFilter) Match(e Event) bool {
+	switch {
+	case f.AccountID != 0 && e.AccountID != f.AccountID,
+		f.Currency != "" && e.Currency != f.Currency,
+		f.State != "" && e.State != f.State,
+		f.Status != "" && e.Status != f.Status,
+		f.SourceType != "" && e.SourceType != f.SourceType,
+		!f.From.IsZero() && e.Time(f.Time).Before(f.From),
+		!f.To.IsZero() && !e.Time(f.Time).Before(f.To):
+		return false
+	}
+	for path, value := range f.Metadata {
+		if !e.Metadata.Match(path, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Time returns the chosen time of event
+func (e Event) Time(t EventTime) time.Time {
+	if t == OccurrenceTime {
+		return e.OccurredAt
+	}
+	return e.CreatedAt
+}
+
+// Wallet returns wallet the event belongs to
+func (e Event) Wallet() Wallet {
+	return Wallet{AccountID: e.AccountID, Currency: e.Currency}
+}
+
+// Diff returns names of payload fields which differ from the other event
+func (e Event) Diff(o Event) []string {
+	var fields []string
+	if e.AccountID != o.AccountID {
+		fields = append(fields, "AccountID")
+	}
+	if e.State != o.State {
+		fields = append(fields, "State")
+	}
+	if e.Amount != o.Amount {
+		fields = append(fields, "Amount")
+	}
+	if e.Currency != o.Currency {
+		fields = append(fields, "Currency")
+	}
+	if e.SourceType != o.SourceType {
+		fields = append(fields, "SourceType")
+	}
+	// database keeps microseconds, time the other event wasn't sent with is taken from ingestion time
+	if !o.OccurredAt.IsZero() &&
+		!e.OccurredAt.Truncate(time.Microsecond).Equal(o.OccurredAt.Truncate(time.Microsecond)) {
+		fields = append(fields, "OccurredAt")
+	}
+	if !e.Metadata.Equal(o.Metadata) {
+		fields = append(fields, "Metadata")
+	}
+	return fields
 }
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata is arbitrary JSON object attached to event by its source, like round or game ID
+type Metadata map[string]interface{}
+
+const (
+	// MetadataMaxSize limits metadata encoded as JSON, in bytes
+	MetadataMaxSize = 4096
+	// MetadataMaxDepth limits nesting of objects and arrays, metadata object itself is the first level
+	MetadataMaxDepth = 3
+	// MetadataMaxKeyLength limits length of every key
+	MetadataMaxKeyLength = 64
+)
+
+// Validate checks metadata against size and depth limits
+func (m Metadata) Validate() error {
+	if len(m) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "Metadata is not valid")
+	}
+	if len(b) > MetadataMaxSize {
+		return errors.Errorf("Metadata cannot be larger than %d bytes", MetadataMaxSize)
+	}
+	return validateMetadataValue(map[string]interface{}(m), 1)
+}
+
+func validateMetadataValue(v interface{}, depth int) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if depth > MetadataMaxDepth {
+			return errors.Errorf("Metadata cannot be nested deeper than %d levels", MetadataMaxDepth)
+		}
+		for k, item := range v {
+			if k == "" || len(k) > MetadataMaxKeyLength {
+				return errors.Errorf("Metadata keys must be 1 to %d characters long", MetadataMaxKeyLength)
+			}
+			if err := validateMetadataValue(item, depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if depth > MetadataMaxDepth {
+			return errors.Errorf("Metadata cannot be nested deeper than %d levels", MetadataMaxDepth)
+		}
+		for _, item := range v {
+			if err := validateMetadataValue(item, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Equal reports whether metadata holds the same data, empty metadata equals missing one
+func (m Metadata) Equal(o Metadata) bool {
+	if len(m) == 0 || len(o) == 0 {
+		return len(m) == len(o)
+	}
+	// map keys are encoded sorted
+	mb, err := json.Marshal(m)
+	if err != nil {
+		return false
+	}
+	ob, err := json.Marshal(o)
+	if err != nil {
+		return false
+	}
+	return string(mb) == string(ob)
+}
+
+// Match reports whether scalar value at the dotted path like "device.os" equals value
+// given as text, which matches string, number or boolean of the same text
+func (m Metadata) Match(path, value string) bool {
+	var v interface{} = map[string]interface{}(m)
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if v, ok = obj[key]; !ok {
+			return false
+		}
+	}
+	for _, candidate := range MetadataValues(value) {
+		switch c := candidate.(type) {
+		case float64:
+			if f, ok := toFloat(v); ok && f == c {
+				return true
+			}
+		default:
+			if v == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MetadataValues returns JSON values the text given in filter matches:
+// the string itself and the number or boolean it represents
+func MetadataValues(value string) []interface{} {
+	values := []interface{}{value}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		switch v.(type) {
+		case float64, bool:
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// MetadataAt returns metadata holding the value at the dotted path
+func MetadataAt(path string, value interface{}) Metadata {
+	keys := strings.Split(path, ".")
+	for i := len(keys) - 1; i > 0; i-- {
+		value = map[string]interface{}{keys[i]: value}
+	}
+	return Metadata{keys[0]: value}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Scan implements sql.Scanner for jsonb columns
+func (m *Metadata) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.Errorf("Cannot scan %T into Metadata", src)
+	}
+	var res Metadata
+	if err := json.Unmarshal(b, &res); err != nil {
+		return errors.Wrap(err, "Cannot scan Metadata")
+	}
+	*m = res
+	return nil
+}
+
+// Value implements driver.Valuer, empty metadata is stored as NULL
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// text keeps lib/pq from sending it as bytea
+	return string(b), nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataValidate(t *testing.T) {
+	a := assert.New(t)
+
+	parse := func(s string) Metadata {
+		var m Metadata
+		a.NoError(json.Unmarshal([]byte(s), &m), s)
+		return m
+	}
+
+	a.NoError(Metadata(nil).Validate())
+	a.NoError(parse(`{"roundId": "r-1", "device": {"os": "ios", "tags": ["a", "b"]}}`).Validate())
+
+	invalid := []Metadata{
+		parse(`{"a": {"b": {"c": {"d": 1}}}}`),
+		parse(`{"a": {"b": [[1]]}}`),
+		parse(`{"": 1}`),
+		{strings.Repeat("k", MetadataMaxKeyLength+1): 1},
+		{"big": strings.Repeat("x", MetadataMaxSize)},
+	}
+	for _, m := range invalid {
+		a.Error(m.Validate(), "%v", m)
+	}
+}
+
+func TestMetadataMatch(t *testing.T) {
+	a := assert.New(t)
+
+	m := Metadata{
+		"roundId": "r-1",
+		"gameId":  float64(42),
+		"bonus":   true,
+		"device":  map[string]interface{}{"os": "ios"},
+	}
+	a.True(m.Match("roundId", "r-1"))
+	a.True(m.Match("gameId", "42"))
+	a.True(m.Match("gameId", "42.0"))
+	a.True(m.Match("bonus", "true"))
+	a.True(m.Match("device.os", "ios"))
+
+	a.False(m.Match("roundId", "r-2"))
+	a.False(m.Match("device", "ios"))
+	a.False(m.Match("device.os.name", "ios"))
+	a.False(m.Match("missing", "r-1"))
+	a.False(Metadata(nil).Match("roundId", "r-1"))
+}
+
+func TestMetadataScanValue(t *testing.T) {
+	a := assert.New(t)
+
+	v, err := Metadata(nil).Value()
+	a.NoError(err)
+	a.Nil(v)
+
+	m := Metadata{"gameId": 42, "device": map[string]interface{}{"os": "ios"}}
+	v, err = m.Value()
+	a.NoError(err)
+
+	var scanned Metadata
+	a.NoError(scanned.Scan([]byte(v.(string))))
+	a.True(m.Equal(scanned))
+
+	a.NoError(scanned.Scan(nil))
+	a.Nil(scanned)
+	a.Error(scanned.Scan(42))
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+)
+
+// Money is an exact amount of money kept as a fixed-point integer
+// number of ten-thousandths of the currency unit
+type Money int64
+
+const (
+	// MoneyScale is the number of decimal places Money keeps
+	MoneyScale = 4
+
+	moneyFactor = 10000
+	// maxMoneyIntDigits matches numeric(18,4) columns in database
+	maxMoneyIntDigits = 14
+	// MaxMoney is the largest amount numeric(18,4) columns hold
+	MaxMoney Money = 999999999999999999
+)
+
+var (
+	ErrInvalidMoney   = errors.New("Invalid money amount")
+	errMoneyNotString = errors.New("Money amount must be a decimal string")
+
+	moneyRegexp = regexp.MustCompile(fmt.Sprintf(`^-?(0|[1-9][0-9]{0,%d})(\.[0-9]{1,%d})?$`,
+		maxMoneyIntDigits-1, MoneyScale))
+)
+
+// MoneyFromInt returns Money for the given number of whole currency units
+func MoneyFromInt(v int64) Money {
+	return Money(v * moneyFactor)
+}
+
+// ParseMoney parses strict decimal string like "10.15" or "-3".
+// Exponents, signs other than leading minus, NaN and Inf are rejected.
+func ParseMoney(s string) (Money, error) {
+	if !moneyRegexp.MatchString(s) {
+		return 0, ErrInvalidMoney
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	fracPart += strings.Repeat("0", MoneyScale-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+	if neg {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+// String returns decimal representation without trailing zeros
+func (m Money) String() string {
+	units := int64(m)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	res := sign + strconv.FormatInt(units/moneyFactor, 10)
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", MoneyScale, units%moneyFactor), "0")
+	if frac != "" {
+		res += "." + frac
+	}
+	return res
+}
+
+// Decimals returns number of significant decimal places
+func (m Money) Decimals() int {
+	units := int64(m)
+	n := MoneyScale
+	for n > 0 && units%10 == 0 {
+		units /= 10
+		n--
+	}
+	return n
+}
+
+// MarshalJSON encodes money as a decimal string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON accepts decimal string only, JSON numbers lose precision on the client side
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	unquoted, err := strconv.Unquote(s)
+	if err != nil || !strings.HasPrefix(s, `"`) {
+		return apperrors.NewBadRequest(errMoneyNotString)
+	}
+	v, err := ParseMoney(unquoted)
+	if err != nil {
+		return apperrors.NewBadRequest(err)
+	}
+	*m = v
+	return nil
+}
+
+// InRange reports whether the amount fits numeric(18,4) columns
+func (m Money) InRange() bool {
+	return m >= -MaxMoney && m <= MaxMoney
+}
+
+// Scan implements sql.Scanner for numeric columns
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		*m = MoneyFromInt(v)
+		return nil
+	}
+	return errors.Errorf("Cannot scan %T into Money", src)
+}
+
+func (m *Money) scanString(s string) error {
+	v, err := ParseMoney(s)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot scan %q into Money", s)
+	}
+	*m = v
+	return nil
+}
+
+// Value implements driver.Valuer
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
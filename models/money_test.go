@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+)
+
+func TestParseMoney(t *testing.T) {
+	a := assert.New(t)
+
+	valid := map[string]Money{
+		"0":                   0,
+		"10":                  100000,
+		"10.15":               101500,
+		"-10.15":              -101500,
+		"0.0001":              1,
+		"99999999999999.9999": 999999999999999999,
+	}
+	for s, expected := range valid {
+		m, err := ParseMoney(s)
+		a.NoError(err, s)
+		a.Equal(expected, m, s)
+	}
+
+	invalid := []string{
+		"", "-", ".5", "5.", "01", "+1", "1e308", "NaN", "Inf", "-Inf",
+		"0.00001", "100000000000000", "1,5", " 1", "0x10",
+	}
+	for _, s := range invalid {
+		_, err := ParseMoney(s)
+		a.Equal(ErrInvalidMoney, err, s)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("0", Money(0).String())
+	a.Equal("10.15", Money(101500).String())
+	a.Equal("-0.0001", Money(-1).String())
+	a.Equal("-7", MoneyFromInt(-7).String())
+	a.Equal(2, Money(101500).Decimals())
+	a.Equal(0, MoneyFromInt(3).Decimals())
+	a.True(MaxMoney.InRange())
+	a.True((-MaxMoney).InRange())
+	a.False((MaxMoney + 1).InRange())
+	a.False((-MaxMoney - 1).InRange())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	a := assert.New(t)
+
+	var v struct {
+		Amount Money `json:"amount"`
+	}
+	a.NoError(json.Unmarshal([]byte(`{"amount": "0.1"}`), &v))
+	a.Equal(Money(1000), v.Amount)
+	// numbers are rejected, they are rounded by clients before being sent
+	err := json.Unmarshal([]byte(`{"amount": 0.2}`), &v)
+	a.IsType(&apperrors.BadRequest{}, err)
+	a.Equal(Money(1000), v.Amount)
+	a.Error(json.Unmarshal([]byte(`{"amount": 1e308}`), &v))
+	err = json.Unmarshal([]byte(`{"amount": "NaN"}`), &v)
+	a.IsType(&apperrors.BadRequest{}, err)
+
+	v.Amount = Money(-101500)
+	data, err := json.Marshal(v)
+	a.NoError(err)
+	a.Equal(`{"amount":"-10.15"}`, string(data))
+}
+
+func TestMoneyScan(t *testing.T) {
+	a := assert.New(t)
+
+	var m Money
+	a.NoError(m.Scan([]byte("10.1500")))
+	a.Equal(Money(101500), m)
+	a.NoError(m.Scan(int64(3)))
+	a.Equal(MoneyFromInt(3), m)
+	a.Error(m.Scan(1.5))
+}
@@ -0,0 +1,107 @@
+// Package outbox delivers changes of events and balances to other systems.
+// Storage writes messages in the same transaction as the change,
+// relay publishes them in order at least once.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// Topics of messages
+const (
+	TopicEventCreated   = "event.created"
+	TopicEventCanceled  = "event.canceled"
+	TopicBalanceChanged = "balance.changed"
+)
+
+// Message is a change to publish
+type Message struct {
+	// ID grows in the order messages are committed
+	ID int64
+	// Key is unique for every change, consumers use it to skip redelivered messages
+	Key       string
+	Topic     string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// EventPayload is payload of event messages, it matches event representation in API responses
+type EventPayload struct {
+	ID                int             `json:"id"`
+	AccountID         int             `json:"accountId"`
+	State             string          `json:"state"`
+	Amount            models.Money    `json:"amount"`
+	Currency          models.Currency `json:"currency"`
+	TransactionID     string          `json:"transactionId"`
+	Status            string          `json:"status"`
+	SourceType        string          `json:"sourceType"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	OccurredAt        time.Time       `json:"occurredAt"`
+	CancellationRunID *int            `json:"cancellationRunId,omitempty"`
+	Metadata          models.Metadata `json:"metadata,omitempty"`
+}
+
+// BalancePayload is payload of balance messages
+type BalancePayload struct {
+	AccountID int             `json:"accountId"`
+	Currency  models.Currency `json:"currency"`
+	Total     models.Money    `json:"total"`
+	Version   int64           `json:"version"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// EventCreated returns message about the stored event
+func EventCreated(e models.Event) Message {
+	return newMessage(TopicEventCreated, "event.created:"+e.TransactionID, newEventPayload(e), e.CreatedAt)
+}
+
+// EventCanceled returns message about the canceled event
+func EventCanceled(e models.Event, at time.Time) Message {
+	e.Status = models.StatusCanceled
+	return newMessage(TopicEventCanceled, fmt.Sprintf("event.canceled:%d", e.ID), newEventPayload(e), at)
+}
+
+// BalanceChanged returns message about new version of the balance
+func BalanceChanged(b models.Balance) Message {
+	key := fmt.Sprintf("balance.changed:%d:%s:%d", b.AccountID, b.Currency, b.Version)
+	return newMessage(TopicBalanceChanged, key, BalancePayload{
+		AccountID: b.AccountID,
+		Currency:  b.Currency,
+		Total:     b.Total,
+		Version:   b.Version,
+		UpdatedAt: b.UpdatedAt,
+	}, b.UpdatedAt)
+}
+
+func newEventPayload(e models.Event) EventPayload {
+	return EventPayload{
+		ID:                e.ID,
+		AccountID:         e.AccountID,
+		State:             strings.ToLower(string(e.State)),
+		Amount:            e.Amount,
+		Currency:          e.Currency,
+		TransactionID:     e.TransactionID,
+		Status:            strings.ToLower(string(e.Status)),
+		SourceType:        e.SourceType,
+		CreatedAt:         e.CreatedAt,
+		OccurredAt:        e.OccurredAt,
+		CancellationRunID: e.CancellationRunID,
+		Metadata:          e.Metadata,
+	}
+}
+
+func newMessage(topic, key string, payload interface{}, at time.Time) Message {
+	// payloads are plain structs, they always encode
+	b, _ := json.Marshal(payload)
+	return Message{
+		Key:       key,
+		Topic:     topic,
+		Payload:   b,
+		CreatedAt: at,
+	}
+}
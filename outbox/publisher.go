@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher delivers messages to other systems.
+// Error means the message wasn't delivered and will be published again.
+type Publisher interface {
+	Publish(ctx context.Context, m Message) error
+}
+
+// messageView is JSON representation of published message
+type messageView struct {
+	ID        int64           `json:"id"`
+	Key       string          `json:"key"`
+	Topic     string          `json:"topic"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Encode returns JSON representation of the message published by stream and HTTP publishers
+func Encode(m Message) ([]byte, error) {
+	b, err := json.Marshal(messageView{
+		ID:        m.ID,
+		Key:       m.Key,
+		Topic:     m.Topic,
+		Payload:   m.Payload,
+		CreatedAt: m.CreatedAt,
+	})
+	return b, errors.Wrap(err, "Can't encode message")
+}
+
+// Decode restores message from its JSON representation
+func Decode(b []byte) (Message, error) {
+	var v messageView
+	if err := json.Unmarshal(b, &v); err != nil {
+		return Message{}, errors.Wrap(err, "Can't decode message")
+	}
+	return Message{
+		ID:        v.ID,
+		Key:       v.Key,
+		Topic:     v.Topic,
+		Payload:   v.Payload,
+		CreatedAt: v.CreatedAt,
+	}, nil
+}
+
+type streamPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStreamPublisher writes every message as JSON line, like to stdout or a file
+func NewStreamPublisher(w io.Writer) *streamPublisher {
+	return &streamPublisher{w: w}
+}
+
+func (p *streamPublisher) Publish(_ context.Context, m Message) error {
+	b, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(b, '\n'))
+	return errors.Wrap(err, "Can't write message")
+}
+
+// KeyHeader carries message key in HTTP requests, so receivers skip redelivered messages
+const KeyHeader = "Idempotency-Key"
+
+type httpPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher posts every message as JSON to the URL, any response but 2xx is a failure
+func NewHTTPPublisher(url string, client *http.Client) *httpPublisher {
+	return &httpPublisher{
+		url:    url,
+		client: client,
+	}
+}
+
+func (p *httpPublisher) Publish(ctx context.Context, m Message) error {
+	b, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "Can't create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(KeyHeader, m.Key)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Can't post message %s", m.Key)
+	}
+	defer resp.Body.Close()
+	// drain body so the connection is reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Message %s is rejected with status %d", m.Key, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+func TestStreamPublisher(t *testing.T) {
+	a := assert.New(t)
+	var buf bytes.Buffer
+	p := NewStreamPublisher(&buf)
+	m := EventCreated(models.Event{ID: 1, TransactionID: "t-1", State: models.StateWin, Status: models.StatusProcessed})
+	m.ID = 10
+	a.NoError(p.Publish(context.Background(), m))
+	a.NoError(p.Publish(context.Background(), m))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if a.Len(lines, 2) {
+		var v struct {
+			ID      int64        `json:"id"`
+			Key     string       `json:"key"`
+			Topic   string       `json:"topic"`
+			Payload EventPayload `json:"payload"`
+		}
+		a.NoError(json.Unmarshal(lines[0], &v))
+		a.Equal(int64(10), v.ID)
+		a.Equal("event.created:t-1", v.Key)
+		a.Equal(TopicEventCreated, v.Topic)
+		a.Equal("win", v.Payload.State)
+		a.Equal("processed", v.Payload.Status)
+	}
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	a := assert.New(t)
+	status := http.StatusNoContent
+	var keys []string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(KeyHeader))
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := NewHTTPPublisher(srv.URL, &http.Client{Timeout: time.Second})
+	m := BalanceChanged(models.Balance{
+		Wallet:  models.Wallet{AccountID: 1, Currency: "EUR"},
+		Total:   models.MoneyFromInt(5),
+		Version: 2,
+	})
+	a.NoError(p.Publish(context.Background(), m))
+	a.Contains(string(body), `"total":"5"`)
+
+	status = http.StatusInternalServerError
+	a.Error(p.Publish(context.Background(), m))
+	a.Equal([]string{"balance.changed:1:EUR:2", "balance.changed:1:EUR:2"}, keys)
+}
@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// Consumers of the outbox, each one is relayed on its own so a failing one doesn't hold up the others
+const (
+	// ConsumerPublisher delivers messages to the publisher chosen by config
+	ConsumerPublisher = "publisher"
+	// ConsumerWebhooks queues webhook deliveries
+	ConsumerWebhooks = "webhooks"
+	// ConsumerStream pushes messages to stream clients
+	ConsumerStream = "stream"
+)
+
+// LockName identifies lock of the process relaying messages to the consumer
+func LockName(consumer string) string {
+	return "outbox:" + consumer
+}
+
+type store interface {
+	// Pending returns the oldest messages the consumer hasn't published yet, in order
+	Pending(ctx context.Context, consumer string, limit int) ([]Message, error)
+	MarkPublished(ctx context.Context, consumer string, ids []int64) error
+}
+
+// locker elects the only process relaying messages, so they are published in order
+type locker interface {
+	TryLock(context.Context) (bool, error)
+	Unlock(context.Context) error
+}
+
+type relay struct {
+	st        store
+	consumer  string
+	pub       Publisher
+	lock      locker
+	batchSize int
+}
+
+// NewRelay returns relay publishing messages pending for the consumer by batches of batchSize,
+// zero publishes all at once
+func NewRelay(st store, consumer string, pub Publisher, lock locker, batchSize int) *relay {
+	return &relay{
+		st:        st,
+		consumer:  consumer,
+		pub:       pub,
+		lock:      lock,
+		batchSize: batchSize,
+	}
+}
+
+// Relay publishes pending messages in order until none is left.
+// It stops at the first failed message, which is published again next time,
+// so a message may be delivered more than once but never after the later ones.
+func (r *relay) Relay(ctx context.Context) (int, error) {
+	leader, err := r.lock.TryLock(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Outbox relay can't take lock")
+	}
+	if !leader {
+		log.Printf("Outbox is relayed to %s by another process, skipping", r.consumer)
+		return 0, nil
+	}
+	total := 0
+	for {
+		messages, err := r.st.Pending(ctx, r.consumer, r.batchSize)
+		if err != nil {
+			return total, errors.Wrap(err, "Outbox relay can't get pending messages")
+		}
+		n, err := r.publish(ctx, messages)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if r.batchSize <= 0 || len(messages) < r.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Release gives up relay lock, so another process takes over without waiting
+func (r *relay) Release(ctx context.Context) error {
+	return errors.Wrap(r.lock.Unlock(ctx), "Outbox relay can't release lock")
+}
+
+func (r *relay) publish(ctx context.Context, messages []Message) (int, error) {
+	published := make([]int64, 0, len(messages))
+	var pubErr error
+	for _, m := range messages {
+		if pubErr = r.pub.Publish(ctx, m); pubErr != nil {
+			pubErr = errors.Wrapf(pubErr, "Outbox relay can't publish message %d", m.ID)
+			break
+		}
+		published = append(published, m.ID)
+	}
+	if len(published) > 0 {
+		if err := r.st.MarkPublished(ctx, r.consumer, published); err != nil {
+			return 0, errors.Wrap(err, "Outbox relay can't mark messages published")
+		}
+	}
+	return len(published), pubErr
+}
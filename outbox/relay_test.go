@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStore struct {
+	mu        sync.Mutex
+	messages  []Message
+	published map[int64]bool
+}
+
+func newTestStore(n int) *testStore {
+	s := &testStore{published: make(map[int64]bool)}
+	for i := 1; i <= n; i++ {
+		s.messages = append(s.messages, Message{ID: int64(i), Key: fmt.Sprintf("key:%d", i)})
+	}
+	return s
+}
+
+func (s *testStore) Pending(_ context.Context, _ string, limit int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []Message
+	for _, m := range s.messages {
+		if !s.published[m.ID] && len(res) < limit {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (s *testStore) MarkPublished(_ context.Context, _ string, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.published[id] = true
+	}
+	return nil
+}
+
+// testPublisher fails messages in fail set
+type testPublisher struct {
+	fail      map[int64]bool
+	published []int64
+}
+
+func (p *testPublisher) Publish(_ context.Context, m Message) error {
+	if p.fail[m.ID] {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, m.ID)
+	return nil
+}
+
+type testLock bool
+
+func (l testLock) TryLock(context.Context) (bool, error) { return bool(l), nil }
+func (l testLock) Unlock(context.Context) error          { return nil }
+
+func TestRelay(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	st := newTestStore(7)
+	pub := &testPublisher{fail: map[int64]bool{5: true}}
+	r := NewRelay(st, ConsumerPublisher, pub, testLock(true), 3)
+
+	n, err := r.Relay(ctx)
+	a.Error(err)
+	a.Equal(4, n)
+	// nothing after the failed message is published
+	a.Equal([]int64{1, 2, 3, 4}, pub.published)
+
+	pub.fail = nil
+	n, err = r.Relay(ctx)
+	a.NoError(err)
+	a.Equal(3, n)
+	a.Equal([]int64{1, 2, 3, 4, 5, 6, 7}, pub.published)
+
+	n, err = r.Relay(ctx)
+	a.NoError(err)
+	a.Equal(0, n)
+}
+
+func TestRelayNotLeader(t *testing.T) {
+	a := assert.New(t)
+	st := newTestStore(2)
+	pub := &testPublisher{}
+	n, err := NewRelay(st, ConsumerPublisher, pub, testLock(false), 10).Relay(context.Background())
+	a.NoError(err)
+	a.Equal(0, n)
+	a.Empty(pub.published)
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Job is periodic task run by scheduler
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Jitter delays every run by random duration up to it, so replicas don't start at once
+	Jitter time.Duration
+	// Timeout limits single run, zero means no limit
+	Timeout time.Duration
+	Run     func(context.Context) error
+}
+
+type scheduler struct {
+	jobs []Job
+
+	// rnd is seeded per process, so replicas draw different jitter
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New returns scheduler without jobs
+func New() *scheduler {
+	return &scheduler{
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers the job, it must be called before Run
+func (s *scheduler) Add(j Job) {
+	s.jobs = append(s.jobs, j)
+}
+
+// Run runs jobs on their schedules until the context is done.
+// Runs of the same job never overlap: the next one is planned after the previous one ends.
+// Stopping doesn't interrupt runs in progress, Run returns when they are finished.
+func (s *scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+}
+
+func (s *scheduler) loop(ctx context.Context, j Job) {
+	for {
+		next := j.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Job %s has no more runs scheduled", j.Name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next) + s.jitter(j.Jitter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		runJob(j)
+	}
+}
+
+// runJob runs the job detached from scheduler context, so stopping lets it finish
+func runJob(j Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", j.Name, r)
+		}
+	}()
+	ctx := context.Background()
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+	if err := j.Run(ctx); err != nil {
+		log.Printf("Job %s failed: %s", j.Name, err)
+	}
+}
+
+func (s *scheduler) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rnd.Int63n(int64(max)))
+}
+
+// StopOnSignals returns context which is done when any of the signals is received
+func StopOnSignals(ctx context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Printf("Received %s, stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Slow runs must not overlap and stop must wait for the run in progress
+func TestSchedulerNoOverlap(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	running, maxRunning, runs := 0, 0, 0
+
+	s := New()
+	s.Add(Job{
+		Name:     "slow",
+		Schedule: every{d: time.Millisecond},
+		Run: func(context.Context) error {
+			mu.Lock()
+			running++
+			runs++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal(1, maxRunning)
+	a.Equal(0, running)
+	a.True(runs > 1)
+}
+
+// Run in progress keeps its own context when scheduler is stopped
+func TestSchedulerRunTimeout(t *testing.T) {
+	a := assert.New(t)
+	results := make(chan error, 10)
+
+	s := New()
+	s.Add(Job{
+		Name:     "timeout",
+		Schedule: every{d: time.Millisecond},
+		Timeout:  10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			results <- ctx.Err()
+			return ctx.Err()
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	s.Run(ctx)
+
+	a.Equal(context.DeadlineExceeded, <-results)
+}
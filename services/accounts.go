@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+type accountsStorage interface {
+	CreateAccount(ctx context.Context, currencies ...models.Currency) (int, error)
+	AddCurrency(ctx context.Context, w models.Wallet) error
+}
+
+type accountsService struct {
+	st accountsStorage
+}
+
+// NewAccounts creates new accounts service
+func NewAccounts(st accountsStorage) *accountsService {
+	return &accountsService{
+		st: st,
+	}
+}
+
+// Create opens account with zero balance in every currency and returns its ID
+func (s *accountsService) Create(ctx context.Context, currencies []models.Currency) (int, error) {
+	id, err := s.st.CreateAccount(ctx, currencies...)
+	return id, errors.Wrap(err, "Accounts service can`t create account")
+}
+
+// AddCurrency opens zero balance of the account in one more currency
+func (s *accountsService) AddCurrency(ctx context.Context, w models.Wallet) error {
+	err := s.st.AddCurrency(ctx, w)
+	return errors.Wrap(err, "Accounts service can`t add currency")
+}
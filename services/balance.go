@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/pkg/errors"
+)
+
+type balanceStorage interface {
+	AccountBalances(ctx context.Context, accountID int) ([]models.Balance, error)
+}
+
+type balance struct {
+	st balanceStorage
+}
+
+// NewBalance creates new balance service
+func NewBalance(st balanceStorage) *balance {
+	return &balance{
+		st: st,
+	}
+}
+
+// Get returns balances of the account in all its currencies
+func (s *balance) Get(ctx context.Context, accountID int) ([]models.Balance, error) {
+	balances, err := s.st.AccountBalances(ctx, accountID)
+	return balances, errors.Wrap(err, "Balance service can`t get balances")
+}
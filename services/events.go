@@ -3,33 +3,71 @@ package services
 import (
 	"context"
 	"log"
-	"sync"
 	"time"
 
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/cancellation"
 	"github.com/djumpen/test-ex-go/models"
 	"github.com/pkg/errors"
 )
 
 const defaultEventStatus = models.StatusProcessed
 
+var errAccountNotFound = errors.New("Account not found")
+
+// CancellationLockName identifies lock of the process running cancellation
+const CancellationLockName = "cancellation"
+
 type eventsStorage interface {
 	Create(context.Context, models.Event) error
-	CancelLastOddEvents(context.Context, int) error
+	CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error)
+	CancelEvents(context.Context, models.Wallet, cancellation.Strategy, cancellation.Options) (cancellation.Result, error)
+	Wallets(context.Context) ([]models.Wallet, error)
+	Get(ctx context.Context, transactionID string) (models.Event, error)
+	List(context.Context, models.EventFilter) ([]models.Event, int, error)
+}
+
+type runsStorage interface {
+	StartRun(context.Context, cancellation.Run) (int, error)
+	FinishRun(context.Context, cancellation.Run) error
+	ListRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error)
+}
+
+// locker elects the only process running cancellation
+type locker interface {
+	// TryLock takes or renews the lock, false means another process holds it
+	TryLock(context.Context) (bool, error)
+	Unlock(context.Context) error
+}
+
+// sourceRules restricts events by their source type
+type sourceRules interface {
+	Check(models.Event) error
 }
 
 type events struct {
-	st eventsStorage
+	st   eventsStorage
+	runs runsStorage
+	// cancellationLock is held by the process running cancellation
+	cancellationLock locker
+	rules            sourceRules
 }
 
 // NewEvents creates new balance service
-func NewEvents(st eventsStorage) *events {
+func NewEvents(st eventsStorage, runs runsStorage, cancellationLock locker, rules sourceRules) *events {
 	return &events{
-		st: st,
+		st:               st,
+		runs:             runs,
+		cancellationLock: cancellationLock,
+		rules:            rules,
 	}
 }
 
 func (s *events) Create(ctx context.Context, e models.Event) (err error) {
 	e.Status = defaultEventStatus
+	if err := s.rules.Check(e); err != nil {
+		return errors.WithStack(err)
+	}
 	err = s.st.Create(ctx, e)
 	if err != nil {
 		return errors.Wrap(err, "Events service can`t create event")
@@ -37,23 +75,155 @@ func (s *events) Create(ctx context.Context, e models.Event) (err error) {
 	return err
 }
 
-var once sync.Once
+// CreateBatch creates events and returns outcome of every one of them.
+// Atomic batch stores nothing unless every event is created or duplicate.
+func (s *events) CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(events))
+	valid := make([]models.Event, 0, len(events))
+	indexes := make([]int, 0, len(events))
+	for i, e := range events {
+		e.Status = defaultEventStatus
+		if err := s.rules.Check(e); err != nil {
+			results[i] = models.BatchResult{Status: models.BatchInvalid, Err: errors.Cause(err)}
+			continue
+		}
+		valid = append(valid, e)
+		indexes = append(indexes, i)
+	}
+	if atomic && models.BatchFailed(results) {
+		models.AbortBatch(results)
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+	stored, err := s.st.CreateBatch(ctx, valid, atomic)
+	if err != nil {
+		return nil, errors.Wrap(err, "Events service can`t create events")
+	}
+	models.MergeBatch(results, indexes, stored)
+	return results, nil
+}
 
-// RunCancellationTask run cancellation task with self-repeat
-func (s *events) RepeatCancellationTask(repeat time.Duration, number int) {
-	once.Do(func() {
-		go func() {
-			for range time.Tick(repeat) {
-				err := s.st.CancelLastOddEvents(context.TODO(), number)
-				if err != nil {
-					log.Print(err) // TODO: error logging
-				}
-			}
-		}()
-	})
+// Get returns event by its transaction ID
+func (s *events) Get(ctx context.Context, transactionID string) (models.Event, error) {
+	e, err := s.st.Get(ctx, transactionID)
+	return e, errors.Wrap(err, "Events service can`t get event")
 }
 
-func (s *events) ExecCancellation(number int) error {
-	err := s.st.CancelLastOddEvents(context.TODO(), number)
+// List returns page of events matching the filter and total number of matching events
+func (s *events) List(ctx context.Context, f models.EventFilter) ([]models.Event, int, error) {
+	events, total, err := s.st.List(ctx, f)
+	return events, total, errors.Wrap(err, "Events service can`t list events")
+}
+
+func (s *events) ExecCancellation(ctx context.Context, strategy cancellation.Strategy, opts cancellation.Options) error {
+	err := s.cancelForAllWallets(ctx, strategy, opts)
 	return errors.Wrap(err, "Events service cancellation error")
 }
+
+// ReleaseCancellation gives up cancellation lock, so another process takes over without waiting
+func (s *events) ReleaseCancellation(ctx context.Context) error {
+	err := s.cancellationLock.Unlock(ctx)
+	return errors.Wrap(err, "Events service can`t release cancellation lock")
+}
+
+// PreviewCancellation computes cancellation run without changing anything.
+// Zero accountID previews all wallets, failures are reported in results.
+func (s *events) PreviewCancellation(ctx context.Context, strategy cancellation.Strategy, opts cancellation.Options,
+	accountID int) ([]cancellation.Result, error) {
+	opts.DryRun = true
+	wallets, err := s.st.Wallets(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Events service can`t preview cancellation")
+	}
+	results := make([]cancellation.Result, 0, len(wallets))
+	for _, w := range wallets {
+		if accountID != 0 && w.AccountID != accountID {
+			continue
+		}
+		res, err := s.st.CancelEvents(ctx, w, strategy, opts)
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "Events service can`t preview cancellation")
+		}
+		res.Err = errors.Cause(err)
+		results = append(results, res)
+	}
+	// an account holds at least one wallet
+	if accountID != 0 && len(results) == 0 {
+		return nil, errors.WithStack(apperrors.NewNotFound(errAccountNotFound))
+	}
+	return results, nil
+}
+
+// ListCancellationRuns returns page of cancellation runs, newest first, and total number of runs
+func (s *events) ListCancellationRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error) {
+	runs, total, err := s.runs.ListRuns(ctx, limit, offset)
+	return runs, total, errors.Wrap(err, "Events service can`t list cancellation runs")
+}
+
+// finishRunTimeout limits recording of run outcome, which happens even if the run itself timed out
+const finishRunTimeout = 10 * time.Second
+
+// cancelForAllWallets runs cancellation for every wallet separately,
+// so low balance of one wallet doesn't block the others.
+// The run and its outcome in every wallet are recorded in run history.
+// Only the process holding cancellation lock runs it, others skip the cycle.
+func (s *events) cancelForAllWallets(ctx context.Context, strategy cancellation.Strategy, opts cancellation.Options) error {
+	leader, err := s.cancellationLock.TryLock(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !leader {
+		log.Print("Cancellation is run by another process, skipping")
+		return nil
+	}
+
+	run := cancellation.Run{
+		Strategy:  strategy.Name(),
+		Params:    strategy.Params(),
+		Partial:   opts.Partial,
+		StartedAt: time.Now().UTC(),
+	}
+	runID, err := s.runs.StartRun(ctx, run)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	run.ID, opts.RunID = runID, runID
+
+	run.Results, run.Err = s.cancelWallets(ctx, strategy, opts)
+	run.FinishedAt = time.Now().UTC()
+
+	finishCtx, cancel := context.WithTimeout(context.Background(), finishRunTimeout)
+	defer cancel()
+	if err := s.runs.FinishRun(finishCtx, run); err != nil {
+		log.Print(err)
+	}
+	return run.Err
+}
+
+func (s *events) cancelWallets(ctx context.Context, strategy cancellation.Strategy,
+	opts cancellation.Options) ([]cancellation.Result, error) {
+	wallets, err := s.st.Wallets(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	results := make([]cancellation.Result, 0, len(wallets))
+	failed := 0
+	for _, w := range wallets {
+		res, err := s.st.CancelEvents(ctx, w, strategy, opts)
+		if err != nil {
+			log.Print(err)
+			res.Wallet, res.Err = w, errors.Cause(err)
+			failed++
+		} else if len(res.SkippedIDs) > 0 {
+			log.Printf("Cancellation skipped events %v of account %d in %s to keep balance non-negative",
+				res.SkippedIDs, w.AccountID, w.Currency)
+		}
+		results = append(results, res)
+	}
+	if failed > 0 {
+		return results, errors.Errorf("Cancellation failed for %d of %d wallets", failed, len(wallets))
+	}
+	return results, nil
+}
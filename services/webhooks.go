@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/sources"
+	"github.com/djumpen/test-ex-go/webhooks"
+)
+
+// secretSize is length of generated endpoint secrets, in bytes
+const secretSize = 32
+
+type webhooksStorage interface {
+	CreateEndpoint(context.Context, webhooks.Endpoint) (webhooks.Endpoint, error)
+	Endpoints(context.Context) ([]webhooks.Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	EnableEndpoint(ctx context.Context, id int) error
+	DeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error)
+	ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64, at time.Time) (int, error)
+}
+
+// sourceTypes knows source types endpoints filter events by
+type sourceTypes interface {
+	Lookup(name string) (sources.Source, bool)
+}
+
+type webhooksService struct {
+	st      webhooksStorage
+	sources sourceTypes
+}
+
+// NewWebhooks creates new webhooks service
+func NewWebhooks(st webhooksStorage, sources sourceTypes) *webhooksService {
+	return &webhooksService{
+		st:      st,
+		sources: sources,
+	}
+}
+
+// CreateEndpoint registers endpoint, secret is generated unless given
+func (s *webhooksService) CreateEndpoint(ctx context.Context, ep webhooks.Endpoint) (webhooks.Endpoint, error) {
+	if ep.Secret == "" {
+		b := make([]byte, secretSize)
+		if _, err := rand.Read(b); err != nil {
+			return ep, errors.Wrap(err, "Webhooks service can`t generate secret")
+		}
+		ep.Secret = hex.EncodeToString(b)
+	}
+	if err := ep.Validate(); err != nil {
+		return ep, errors.WithStack(apperrors.NewBadRequest(err))
+	}
+	names := make([]string, 0, len(ep.SourceTypes))
+	for _, name := range ep.SourceTypes {
+		source, ok := s.sources.Lookup(name)
+		if !ok {
+			return ep, errors.WithStack(apperrors.NewBadRequest(errors.Errorf("Source type %q is unknown", name)))
+		}
+		// events keep the name of their source type
+		names = append(names, source.Name)
+	}
+	ep.SourceTypes = names
+	ep, err := s.st.CreateEndpoint(ctx, ep)
+	return ep, errors.Wrap(err, "Webhooks service can`t create endpoint")
+}
+
+// ListEndpoints returns all endpoints in order of creation
+func (s *webhooksService) ListEndpoints(ctx context.Context) ([]webhooks.Endpoint, error) {
+	endpoints, err := s.st.Endpoints(ctx)
+	return endpoints, errors.Wrap(err, "Webhooks service can`t list endpoints")
+}
+
+// DeleteEndpoint removes endpoint, its pending deliveries and dead letters
+func (s *webhooksService) DeleteEndpoint(ctx context.Context, id int) error {
+	return errors.Wrap(s.st.DeleteEndpoint(ctx, id), "Webhooks service can`t delete endpoint")
+}
+
+// EnableEndpoint lets deliveries to the endpoint disabled after failures go again
+func (s *webhooksService) EnableEndpoint(ctx context.Context, id int) error {
+	return errors.Wrap(s.st.EnableEndpoint(ctx, id), "Webhooks service can`t enable endpoint")
+}
+
+// ListDeadLetters returns page of dead letters, of all endpoints if endpointID is zero
+func (s *webhooksService) ListDeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error) {
+	letters, total, err := s.st.DeadLetters(ctx, endpointID, limit, offset)
+	return letters, total, errors.Wrap(err, "Webhooks service can`t list dead letters")
+}
+
+// ReplayDeadLetters queues dead letters for delivery again with attempts reset
+func (s *webhooksService) ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64) (int, error) {
+	n, err := s.st.ReplayDeadLetters(ctx, endpointID, ids, time.Now().UTC())
+	return n, errors.Wrap(err, "Webhooks service can`t replay dead letters")
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/sources"
+	"github.com/djumpen/test-ex-go/webhooks"
+)
+
+// stubEndpoints stores created endpoints, other calls aren't expected
+type stubEndpoints struct {
+	webhooksStorage
+	created []webhooks.Endpoint
+}
+
+func (s *stubEndpoints) CreateEndpoint(_ context.Context, ep webhooks.Endpoint) (webhooks.Endpoint, error) {
+	s.created = append(s.created, ep)
+	return ep, nil
+}
+
+func TestCreateEndpointSourceTypes(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	registry, err := sources.NewRegistry(nil)
+	a.NoError(err)
+	st := &stubEndpoints{}
+	svc := NewWebhooks(st, registry)
+
+	ep, err := svc.CreateEndpoint(ctx, webhooks.Endpoint{URL: "https://example.com", SourceTypes: []string{"Game", "server"}})
+	a.NoError(err)
+	a.Equal([]string{"game", "server"}, ep.SourceTypes)
+
+	_, err = svc.CreateEndpoint(ctx, webhooks.Endpoint{URL: "https://example.com", SourceTypes: []string{"game", "casino"}})
+	_, ok := errors.Cause(err).(*apperrors.BadRequest)
+	a.True(ok, err)
+	a.Len(st.created, 1)
+}
@@ -0,0 +1,129 @@
+package sources
+
+import (
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// Source is settings of one source type
+type Source struct {
+	// Name is value of Source-Type header in lower case
+	Name        string
+	DisplayName string
+	Enabled     bool
+	// Credentials are tokens accepted from the source, empty means no token required
+	Credentials []string
+	Rule        Rule
+}
+
+// Authorize checks token sent by the source
+func (s Source) Authorize(token string) bool {
+	if len(s.Credentials) == 0 {
+		return true
+	}
+	ok := false
+	for _, c := range s.Credentials {
+		// compare every credential to keep timing independent of the match
+		if subtle.ConstantTimeCompare([]byte(c), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// defaultSources are used when config defines no source types
+var defaultSources = []string{"game", "server", "payment"}
+
+type registry struct {
+	mu      sync.RWMutex
+	sources map[string]Source
+}
+
+// NewRegistry returns source types defined by config
+func NewRegistry(c map[string]config.SourceTypeConfig) (*registry, error) {
+	r := &registry{}
+	if err := r.Reload(c); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload replaces all source types at once, invalid config leaves them untouched
+func (r *registry) Reload(c map[string]config.SourceTypeConfig) error {
+	sources, err := fromConfig(c)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.sources = sources
+	r.mu.Unlock()
+	return nil
+}
+
+// Lookup returns source type by its name in any case
+func (r *registry) Lookup(name string) (Source, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.sources[strings.ToLower(name)]
+	return s, ok
+}
+
+// List returns all source types sorted by name
+func (r *registry) List() []Source {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Source, 0, len(r.sources))
+	for _, s := range r.sources {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Check returns validation error if the event breaks rule of its source,
+// events of unknown sources are not restricted
+func (r *registry) Check(e models.Event) error {
+	if s, ok := r.Lookup(e.SourceType); ok {
+		return s.Rule.Check(e)
+	}
+	return nil
+}
+
+func fromConfig(c map[string]config.SourceTypeConfig) (map[string]Source, error) {
+	sources := make(map[string]Source, len(c))
+	if len(c) == 0 {
+		for _, name := range defaultSources {
+			sources[name] = Source{Name: name, DisplayName: name, Enabled: true}
+		}
+		return sources, nil
+	}
+	for name, sc := range c {
+		// viper keeps keys in lower case, header is lowercased too
+		name = strings.ToLower(name)
+		rule, err := ruleFromConfig(sc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid rule of source %s", name)
+		}
+		s := Source{
+			Name:        name,
+			DisplayName: sc.DisplayName,
+			Enabled:     sc.Enabled == nil || *sc.Enabled,
+			Credentials: sc.Credentials,
+			Rule:        rule,
+		}
+		if s.DisplayName == "" {
+			s.DisplayName = name
+		}
+		sources[name] = s
+	}
+	return sources, nil
+}
@@ -0,0 +1,61 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/config"
+)
+
+func TestRegistryDefaults(t *testing.T) {
+	a := assert.New(t)
+	r, err := NewRegistry(nil)
+	if !a.NoError(err) {
+		return
+	}
+	for _, name := range []string{"game", "Server", "PAYMENT"} {
+		s, ok := r.Lookup(name)
+		a.True(ok, name)
+		a.True(s.Enabled, name)
+	}
+	_, ok := r.Lookup("partner")
+	a.False(ok)
+}
+
+func TestRegistryReload(t *testing.T) {
+	a := assert.New(t)
+	disabled := false
+	r, err := NewRegistry(map[string]config.SourceTypeConfig{
+		"game":    {},
+		"Partner": {DisplayName: "Partner Ltd", Credentials: []string{"secret"}},
+	})
+	if !a.NoError(err) {
+		return
+	}
+	s, ok := r.Lookup("partner")
+	a.True(ok)
+	a.Equal("Partner Ltd", s.DisplayName)
+	a.True(s.Enabled)
+	a.True(s.Authorize("secret"))
+	a.False(s.Authorize(""))
+	a.False(s.Authorize("secret2"))
+	game, _ := r.Lookup("game")
+	a.Equal("game", game.DisplayName)
+	a.True(game.Authorize(""))
+
+	a.NoError(r.Reload(map[string]config.SourceTypeConfig{
+		"game":    {Enabled: &disabled},
+		"partner": {},
+	}))
+	game, _ = r.Lookup("game")
+	a.False(game.Enabled)
+	s, _ = r.Lookup("partner")
+	a.True(s.Authorize(""))
+
+	// invalid config keeps the current source types
+	a.Error(r.Reload(map[string]config.SourceTypeConfig{"server": {MinAmount: "-1"}}))
+	_, ok = r.Lookup("server")
+	a.False(ok)
+	a.Len(r.List(), 2)
+}
@@ -0,0 +1,102 @@
+package sources
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+// Rule restricts events sent by one source type
+type Rule struct {
+	// States allowed for the source, empty allows all
+	States []models.EventState
+	// MinAmount and MaxAmount limit absolute event amount in any currency, zero means no limit
+	MinAmount models.Money
+	MaxAmount models.Money
+	// LateWindow rejects events which occurred longer ago, zero accepts any
+	LateWindow time.Duration
+}
+
+// Check returns validation error if the event breaks the rule
+func (r Rule) Check(e models.Event) error {
+	return r.check(e, time.Now())
+}
+
+func (r Rule) check(e models.Event, now time.Time) error {
+	if len(r.States) > 0 && !stateIn(e.State, r.States) {
+		return apperrors.NewValidation("event",
+			errors.Errorf("Source %s cannot send %s events", e.SourceType, e.State))
+	}
+	amount := e.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	if r.MinAmount > 0 && amount < r.MinAmount {
+		return apperrors.NewValidation("event",
+			errors.Errorf("Source %s cannot send amounts below %s", e.SourceType, r.MinAmount))
+	}
+	if r.MaxAmount > 0 && amount > r.MaxAmount {
+		return apperrors.NewValidation("event",
+			errors.Errorf("Source %s cannot send amounts above %s", e.SourceType, r.MaxAmount))
+	}
+	// events without occurrence time are received right now
+	if r.LateWindow > 0 && !e.OccurredAt.IsZero() && e.OccurredAt.Before(now.Add(-r.LateWindow)) {
+		return apperrors.NewValidation("event",
+			errors.Errorf("Source %s cannot send events which occurred more than %s ago", e.SourceType, r.LateWindow))
+	}
+	return nil
+}
+
+func ruleFromConfig(sc config.SourceTypeConfig) (Rule, error) {
+	var rule Rule
+	for _, s := range sc.States {
+		state := models.EventState(strings.ToUpper(s))
+		if state != models.StateWin && state != models.StateLoss {
+			return rule, errors.Errorf("Unknown state %q", s)
+		}
+		rule.States = append(rule.States, state)
+	}
+	var err error
+	if rule.MinAmount, err = parseLimit(sc.MinAmount); err != nil {
+		return rule, errors.Wrap(err, "Invalid min amount")
+	}
+	if rule.MaxAmount, err = parseLimit(sc.MaxAmount); err != nil {
+		return rule, errors.Wrap(err, "Invalid max amount")
+	}
+	if rule.MaxAmount > 0 && rule.MinAmount > rule.MaxAmount {
+		return rule, errors.New("Min amount is above max amount")
+	}
+	if sc.LateWindow < 0 {
+		return rule, errors.New("Late window cannot be negative")
+	}
+	rule.LateWindow = sc.LateWindow
+	return rule, nil
+}
+
+func parseLimit(s string) (models.Money, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := models.ParseMoney(s)
+	if err != nil {
+		return 0, err
+	}
+	if m < 0 {
+		return 0, errors.New("Limit cannot be negative")
+	}
+	return m, nil
+}
+
+func stateIn(s models.EventState, states []models.EventState) bool {
+	for _, v := range states {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package sources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/config"
+	"github.com/djumpen/test-ex-go/models"
+)
+
+func TestRulesCheck(t *testing.T) {
+	a := assert.New(t)
+	rules, err := NewRegistry(map[string]config.SourceTypeConfig{
+		"Payment": {States: []string{"win"}, MaxAmount: "100"},
+		"game":    {MinAmount: "0.5"},
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	event := func(source string, amount string) models.Event {
+		m, err := models.ParseMoney(amount)
+		a.NoError(err)
+		state := models.StateWin
+		if m < 0 {
+			state = models.StateLoss
+		}
+		return models.Event{SourceType: source, State: state, Amount: m}
+	}
+
+	a.NoError(rules.Check(event("payment", "100")))
+	a.NoError(rules.Check(event("game", "-0.5")))
+	a.NoError(rules.Check(event("server", "-100000")))
+
+	for _, e := range []models.Event{
+		event("payment", "-1"),
+		event("payment", "100.01"),
+		event("game", "0.49"),
+		event("game", "-0.1"),
+	} {
+		err := rules.Check(e)
+		_, ok := errors.Cause(err).(*apperrors.Validation)
+		a.True(ok, "%s %s", e.SourceType, e.Amount)
+	}
+}
+
+func TestRegistryInvalid(t *testing.T) {
+	for _, c := range []config.SourceTypeConfig{
+		{States: []string{"draw"}},
+		{MaxAmount: "1e3"},
+		{MinAmount: "-1"},
+		{MinAmount: "10", MaxAmount: "5"},
+		{LateWindow: -time.Hour},
+	} {
+		_, err := NewRegistry(map[string]config.SourceTypeConfig{"game": c})
+		assert.Error(t, err, "%+v", c)
+	}
+}
+
+func TestRuleLateWindow(t *testing.T) {
+	a := assert.New(t)
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	rule := Rule{LateWindow: time.Hour}
+
+	a.NoError(rule.check(models.Event{}, now))
+	a.NoError(rule.check(models.Event{OccurredAt: now.Add(-time.Hour)}, now))
+	err := rule.check(models.Event{SourceType: "game", OccurredAt: now.Add(-time.Hour - time.Second)}, now)
+	_, ok := errors.Cause(err).(*apperrors.Validation)
+	a.True(ok)
+
+	a.NoError(Rule{}.check(models.Event{OccurredAt: now.Add(-24 * time.Hour)}, now))
+}
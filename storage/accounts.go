@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/jinzhu/gorm"
+)
+
+type accounts struct {
+	db *gorm.DB
+}
+
+// NewAccounts returns Accounts storage
+func NewAccounts(db *gorm.DB) *accounts {
+	return &accounts{
+		db: db,
+	}
+}
+
+type account struct {
+	ID int
+}
+
+const (
+	balanceAccountFK     = "balance_accounts_id_fk"
+	balanceCurrencyIndex = "balance_account_id_currency_uindex"
+)
+
+// CreateAccount opens new account with zero balance in every given currency
+func (s *accounts) CreateAccount(ctx context.Context, currencies ...models.Currency) (int, error) {
+	var acc account
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		err := tx.Raw("INSERT INTO accounts DEFAULT VALUES RETURNING id").
+			Scan(&acc).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, c := range currencies {
+			if err := openBalance(ctx, tx, models.Wallet{AccountID: acc.ID, Currency: c}); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Storage error while creating account")
+	}
+	return acc.ID, nil
+}
+
+// AddCurrency opens zero balance of the account in given currency
+func (s *accounts) AddCurrency(ctx context.Context, w models.Wallet) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return openBalance(ctx, tx, w)
+	})
+	if isForeignKeyViolation(err, balanceAccountFK) {
+		return errors.WithStack(apperrors.NewNotFound(errAccountNotFound))
+	}
+	if isUniqueViolation(err, balanceCurrencyIndex) {
+		return errors.WithStack(apperrors.NewConflict(errCurrencyHeld, nil))
+	}
+	return errors.Wrap(err, "Storage error while adding currency")
+}
+
+// AccountBalances returns balances of the account in all its currencies
+func (s *accounts) AccountBalances(ctx context.Context, accountID int) ([]models.Balance, error) {
+	var balances []models.Balance
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Raw(`
+			SELECT account_id, currency, total, version, updated_at
+			FROM balance WHERE account_id = ? ORDER BY currency`, accountID).
+			Scan(&balances).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Storage error while getting balances")
+	}
+	if len(balances) == 0 {
+		return nil, errors.WithStack(apperrors.NewNotFound(errAccountNotFound))
+	}
+	return balances, nil
+}
+
+func openBalance(ctx context.Context, tx *gorm.DB, w models.Wallet) error {
+	err := tx.Exec("INSERT INTO balance(account_id, currency, total) VALUES(?, ?, 0)",
+		w.AccountID, w.Currency).Error
+	return errors.WithStack(err)
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/cancellation"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/jinzhu/gorm"
+)
+
+type cancellationRuns struct {
+	db *gorm.DB
+}
+
+// NewCancellationRuns returns storage of cancellation run history
+func NewCancellationRuns(db *gorm.DB) *cancellationRuns {
+	return &cancellationRuns{
+		db: db,
+	}
+}
+
+type cancellationRun struct {
+	ID         int
+	Strategy   string
+	Params     string
+	Partial    bool
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Error      string
+}
+
+func (cancellationRun) TableName() string {
+	return "cancellation_runs"
+}
+
+type cancellationRunWallet struct {
+	RunID         int
+	AccountID     int
+	Currency      models.Currency
+	EventIDs      string `gorm:"column:event_ids"`
+	SkippedIDs    string `gorm:"column:skipped_ids"`
+	BalanceBefore models.Money
+	BalanceAfter  models.Money
+	Error         string
+}
+
+func (cancellationRunWallet) TableName() string {
+	return "cancellation_run_wallets"
+}
+
+// StartRun records beginning of the run and returns its ID
+func (s *cancellationRuns) StartRun(ctx context.Context, run cancellation.Run) (int, error) {
+	params, err := json.Marshal(run.Params)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	row := cancellationRun{
+		Strategy:  run.Strategy,
+		Params:    string(params),
+		Partial:   run.Partial,
+		StartedAt: run.StartedAt.UTC(),
+	}
+	err = withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Create(&row).Error
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Storage error while starting cancellation run")
+	}
+	return row.ID, nil
+}
+
+// FinishRun records outcome of the run in every wallet
+func (s *cancellationRuns) FinishRun(ctx context.Context, run cancellation.Run) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		err := tx.Model(&cancellationRun{ID: run.ID}).Updates(map[string]interface{}{
+			"finished_at": run.FinishedAt.UTC(),
+			"error":       errorText(run.Err),
+		}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, res := range run.Results {
+			row, err := newCancellationRunWallet(run.ID, res)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := tx.Create(&row).Error; err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "Storage error while finishing cancellation run")
+}
+
+// ListRuns returns page of runs, newest first, and total number of runs
+func (s *cancellationRuns) ListRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error) {
+	var (
+		total   int
+		rows    []cancellationRun
+		wallets []cancellationRunWallet
+	)
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		if err := tx.Model(&cancellationRun{}).Count(&total).Error; err != nil {
+			return errors.Wrap(err, "Storage error while counting cancellation runs")
+		}
+		q := tx.Order("id DESC").Offset(offset)
+		if limit > 0 {
+			q = q.Limit(limit)
+		}
+		if err := q.Find(&rows).Error; err != nil {
+			return errors.Wrap(err, "Storage error while listing cancellation runs")
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]int, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		err := tx.Where("run_id IN (?)", ids).Order("account_id, currency").Find(&wallets).Error
+		return errors.Wrap(err, "Storage error while listing cancellation runs")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	runs := make([]cancellation.Run, 0, len(rows))
+	for _, row := range rows {
+		run, err := row.toRun(wallets)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "Storage error while listing cancellation runs")
+		}
+		runs = append(runs, run)
+	}
+	return runs, total, nil
+}
+
+func newCancellationRunWallet(runID int, res cancellation.Result) (cancellationRunWallet, error) {
+	eventIDs, err := json.Marshal(nonNilIDs(res.EventIDs))
+	if err != nil {
+		return cancellationRunWallet{}, errors.WithStack(err)
+	}
+	skippedIDs, err := json.Marshal(nonNilIDs(res.SkippedIDs))
+	if err != nil {
+		return cancellationRunWallet{}, errors.WithStack(err)
+	}
+	return cancellationRunWallet{
+		RunID:         runID,
+		AccountID:     res.Wallet.AccountID,
+		Currency:      res.Wallet.Currency,
+		EventIDs:      string(eventIDs),
+		SkippedIDs:    string(skippedIDs),
+		BalanceBefore: res.BalanceBefore,
+		BalanceAfter:  res.BalanceAfter,
+		Error:         errorText(res.Err),
+	}, nil
+}
+
+func (row cancellationRun) toRun(wallets []cancellationRunWallet) (cancellation.Run, error) {
+	run := cancellation.Run{
+		ID:        row.ID,
+		Strategy:  row.Strategy,
+		Partial:   row.Partial,
+		StartedAt: row.StartedAt,
+		Err:       textError(row.Error),
+	}
+	if row.FinishedAt != nil {
+		run.FinishedAt = *row.FinishedAt
+	}
+	if err := json.Unmarshal([]byte(row.Params), &run.Params); err != nil {
+		return run, errors.WithStack(err)
+	}
+	for _, w := range wallets {
+		if w.RunID != row.ID {
+			continue
+		}
+		res := cancellation.Result{
+			Wallet:        models.Wallet{AccountID: w.AccountID, Currency: w.Currency},
+			BalanceBefore: w.BalanceBefore,
+			BalanceAfter:  w.BalanceAfter,
+			Err:           textError(w.Error),
+		}
+		if err := json.Unmarshal([]byte(w.EventIDs), &res.EventIDs); err != nil {
+			return run, errors.WithStack(err)
+		}
+		if err := json.Unmarshal([]byte(w.SkippedIDs), &res.SkippedIDs); err != nil {
+			return run, errors.WithStack(err)
+		}
+		run.Results = append(run.Results, res)
+	}
+	return run, nil
+}
+
+func nonNilIDs(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// textError restores stored error message, empty one means no error
+func textError(text string) error {
+	if text == "" {
+		return nil
+	}
+	return errors.New(text)
+}
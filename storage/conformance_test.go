@@ -0,0 +1,1096 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/cancellation"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+	"github.com/djumpen/test-ex-go/webhooks"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventsBackend is implemented by every events storage
+type eventsBackend interface {
+	Create(context.Context, models.Event) error
+	CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error)
+	CancelEvents(context.Context, models.Wallet, cancellation.Strategy, cancellation.Options) (cancellation.Result, error)
+	Balance(context.Context, models.Wallet) (models.Money, error)
+	Wallets(context.Context) ([]models.Wallet, error)
+	Get(ctx context.Context, transactionID string) (models.Event, error)
+	List(context.Context, models.EventFilter) ([]models.Event, int, error)
+}
+
+// runsBackend is implemented by every cancellation run history storage
+type runsBackend interface {
+	StartRun(context.Context, cancellation.Run) (int, error)
+	FinishRun(context.Context, cancellation.Run) error
+	ListRuns(ctx context.Context, limit, offset int) ([]cancellation.Run, int, error)
+}
+
+// outboxBackend is implemented by every outbox storage
+type outboxBackend interface {
+	Pending(ctx context.Context, consumer string, limit int) ([]outbox.Message, error)
+	MarkPublished(ctx context.Context, consumer string, ids []int64) error
+}
+
+// webhooksBackend is implemented by every webhooks storage
+type webhooksBackend interface {
+	CreateEndpoint(context.Context, webhooks.Endpoint) (webhooks.Endpoint, error)
+	Endpoints(context.Context) ([]webhooks.Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	EnqueueDeliveries(context.Context, []webhooks.Delivery) error
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhooks.Delivery, error)
+	MarkDelivered(ctx context.Context, id int64, at time.Time) error
+	RetryDelivery(ctx context.Context, id int64, next time.Time, lastErr string) error
+	BuryDelivery(ctx context.Context, id int64, at time.Time, lastErr string) error
+	EndpointFailed(ctx context.Context, id int, at time.Time, disableAfter int) (bool, error)
+	EndpointSucceeded(ctx context.Context, id int) error
+	EnableEndpoint(ctx context.Context, id int) error
+	DeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error)
+	ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64, at time.Time) (int, error)
+}
+
+type createAccountFunc func(context.Context, ...models.Currency) (int, error)
+
+// accountsBackend is implemented by every accounts storage
+type accountsBackend interface {
+	AccountBalances(ctx context.Context, accountID int) ([]models.Balance, error)
+	AddCurrency(context.Context, models.Wallet) error
+}
+
+// testEventsConformance checks rules every events storage must follow.
+// Each case opens its own accounts, so the storage may be shared between them.
+func testEventsConformance(t *testing.T, st eventsBackend, accounts accountsBackend, runs runsBackend,
+	messages outboxBackend, createAccount createAccountFunc) {
+	newWallet := func(t *testing.T) models.Wallet {
+		accID, err := createAccount(context.Background(), "EUR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return models.Wallet{AccountID: accID, Currency: "EUR"}
+	}
+
+	t.Run("NonNegativeBalance", func(t *testing.T) {
+		testNonNegativeBalance(t, st, newWallet(t))
+	})
+	t.Run("BalanceRange", func(t *testing.T) {
+		testBalanceRange(t, st, newWallet(t))
+	})
+	t.Run("CancelLastOddEvents", func(t *testing.T) {
+		testCancelLastOddEvents(t, st, newWallet(t))
+	})
+	t.Run("CancellationStrategies", func(t *testing.T) {
+		testCancellationStrategies(t, st, newWallet)
+	})
+	t.Run("CancellationDryRun", func(t *testing.T) {
+		testCancellationDryRun(t, st, newWallet(t))
+	})
+	t.Run("PartialCancellation", func(t *testing.T) {
+		testPartialCancellation(t, st, newWallet(t))
+	})
+	t.Run("CancellationRuns", func(t *testing.T) {
+		testCancellationRuns(t, st, runs, newWallet(t))
+	})
+	t.Run("BalancePerWallet", func(t *testing.T) {
+		testBalancePerWallet(t, st, createAccount)
+	})
+	t.Run("IdempotentCreate", func(t *testing.T) {
+		testIdempotentCreate(t, st, newWallet(t))
+	})
+	t.Run("GetAndList", func(t *testing.T) {
+		testGetAndList(t, st, newWallet(t))
+	})
+	t.Run("Accounts", func(t *testing.T) {
+		testAccounts(t, accounts, createAccount)
+	})
+	t.Run("BalanceVersion", func(t *testing.T) {
+		testBalanceVersion(t, st, accounts, newWallet(t))
+	})
+	t.Run("Metadata", func(t *testing.T) {
+		testMetadata(t, st, newWallet(t))
+	})
+	t.Run("OccurrenceTime", func(t *testing.T) {
+		testOccurrenceTime(t, st, newWallet(t))
+	})
+	t.Run("Outbox", func(t *testing.T) {
+		testOutbox(t, st, messages, newWallet(t))
+	})
+	t.Run("EmptyCancellation", func(t *testing.T) {
+		testEmptyCancellation(t, st, accounts, messages, newWallet(t))
+	})
+	t.Run("IndependentBatch", func(t *testing.T) {
+		testIndependentBatch(t, st, accounts, newWallet(t))
+	})
+	t.Run("AtomicBatch", func(t *testing.T) {
+		testAtomicBatch(t, st, newWallet(t))
+	})
+}
+
+// Amounts and balances must fit numeric(18,4) columns
+func testBalanceRange(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+	large := models.MaxMoney - models.MaxMoney%models.MoneyFromInt(1)
+
+	err := st.Create(ctx, genTestEvent(w, models.MaxMoney+1))
+	a.IsType(&apperrors.Validation{}, errors.Cause(err))
+	a.NoError(st.Create(ctx, genTestEvent(w, large)))
+	err = st.Create(ctx, genTestEvent(w, large))
+	a.IsType(&apperrors.BadRequest{}, errors.Cause(err))
+
+	results, err := st.CreateBatch(ctx, []models.Event{genTestEvent(w, large)}, false)
+	a.NoError(err)
+	if a.Len(results, 1) {
+		a.Equal(models.BatchInvalid, results[0].Status)
+		a.IsType(&apperrors.BadRequest{}, results[0].Err)
+	}
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(large, bal)
+}
+
+// Concurrent events must never make balance negative
+func testNonNegativeBalance(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+
+	var assumeTotal models.Money
+	var tl sync.Mutex
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			amount := models.Money(rand.Intn(10000) * 100)
+			var e models.Event
+			if i%5 == 0 { // StateWin
+				e = genTestEvent(w, amount)
+			} else { // StateLoss
+				amount = amount * -1
+				e = genTestEvent(w, amount)
+			}
+			err := st.Create(ctx, e)
+			if err != nil && errors.Cause(err) != errNegativeBalance {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				tl.Lock()
+				assumeTotal += e.Amount
+				tl.Unlock()
+			}
+		}(i)
+		t := time.Duration(rand.Int63n(5))
+		time.Sleep(t * time.Millisecond)
+	}
+
+	wg.Wait()
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+
+	t.Logf("Total balance: %s", bal)
+
+	a.Equal(assumeTotal, bal)
+
+	if bal < 0 {
+		t.Error("Negative balance")
+	}
+}
+
+func testCancelLastOddEvents(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	assumeBalance := models.MoneyFromInt(520)
+
+	for i := 0; i < 40; i++ {
+		e := genTestEvent(w, models.MoneyFromInt(int64(i)+1))
+		err := st.Create(ctx, e)
+		a.NoError(err)
+	}
+
+	_, err := st.CancelEvents(ctx, w, cancellation.OddRows(10), cancellation.Options{})
+	a.NoError(err)
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+
+	a.Equal(assumeBalance, bal)
+
+	// canceled events are skipped on the next run
+	_, err = st.CancelEvents(ctx, w, cancellation.OddRows(10), cancellation.Options{})
+	a.NoError(err)
+
+	bal, err = st.Balance(ctx, w)
+	a.NoError(err)
+
+	a.Equal(assumeBalance, bal)
+}
+
+func testCancellationStrategies(t *testing.T, st eventsBackend, newWallet func(t *testing.T) models.Wallet) {
+	ctx := context.Background()
+	// wins 1..10 of alternating source types
+	fill := func(t *testing.T, w models.Wallet) {
+		for i := 0; i < 10; i++ {
+			e := genTestEvent(w, models.MoneyFromInt(int64(i)+1))
+			e.SourceType = "game"
+			if i%2 == 1 {
+				e.SourceType = "payment"
+			}
+			if err := st.Create(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cases := []struct {
+		name     string
+		strategy cancellation.Strategy
+		// balance after the first and the second run
+		balance, again int64
+	}{
+		// 10+9+8 canceled, then 7+6+5
+		{"Last", cancellation.Last(3), 28, 10},
+		// payment events are 2,4,6,8,10, 10+8 canceled, then 6+4
+		{"SourceType", cancellation.BySourceType("payment", 2), 37, 27},
+		// no events are old enough
+		{"OlderThan", cancellation.OlderThan(time.Hour, 0), 55, 55},
+		// every event is a win
+		{"State", cancellation.ByState(models.StateWin, 100), 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := assert.New(t)
+			w := newWallet(t)
+			fill(t, w)
+
+			_, err := st.CancelEvents(ctx, w, c.strategy, cancellation.Options{})
+			a.NoError(err)
+			bal, err := st.Balance(ctx, w)
+			a.NoError(err)
+			a.Equal(models.MoneyFromInt(c.balance), bal)
+
+			// canceled events are not chosen again
+			_, err = st.CancelEvents(ctx, w, c.strategy, cancellation.Options{})
+			a.NoError(err)
+			bal, err = st.Balance(ctx, w)
+			a.NoError(err)
+			a.Equal(models.MoneyFromInt(c.again), bal)
+		})
+	}
+}
+
+// Dry run must predict the real run without changing anything
+func testCancellationDryRun(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		a.NoError(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(int64(i)+1))))
+	}
+	strategy := cancellation.Last(2)
+
+	preview, err := st.CancelEvents(ctx, w, strategy, cancellation.Options{DryRun: true})
+	a.NoError(err)
+	a.True(preview.DryRun)
+	a.Len(preview.EventIDs, 2)
+	a.Equal(models.MoneyFromInt(15), preview.BalanceBefore)
+	a.Equal(models.MoneyFromInt(6), preview.BalanceAfter)
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(models.MoneyFromInt(15), bal)
+
+	res, err := st.CancelEvents(ctx, w, strategy, cancellation.Options{})
+	a.NoError(err)
+	a.False(res.DryRun)
+	a.Equal(preview.EventIDs, res.EventIDs)
+	a.Equal(preview.BalanceAfter, res.BalanceAfter)
+
+	// failing run is still previewed
+	a.NoError(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(-6))))
+	preview, err = st.CancelEvents(ctx, w, cancellation.ByState(models.StateWin, 10), cancellation.Options{DryRun: true})
+	a.Equal(errNegativeBalance, errors.Cause(err))
+	a.Equal(models.MoneyFromInt(-6), preview.BalanceAfter)
+	a.Len(preview.EventIDs, 3)
+}
+
+// Partial run must cancel what it can instead of failing
+func testPartialCancellation(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	// balance 100+5+10-50 = 65, all wins can't be canceled
+	var stored []models.Event
+	for _, amount := range []int64{100, 5, 10, -50} {
+		e := genTestEvent(w, models.MoneyFromInt(amount))
+		a.NoError(st.Create(ctx, e))
+		e, err := st.Get(ctx, e.TransactionID)
+		a.NoError(err)
+		stored = append(stored, e)
+	}
+	strategy := cancellation.ByState(models.StateWin, 10)
+
+	_, err := st.CancelEvents(ctx, w, strategy, cancellation.Options{})
+	a.Equal(errNegativeBalance, errors.Cause(err))
+
+	res, err := st.CancelEvents(ctx, w, strategy, cancellation.Options{Partial: true})
+	a.NoError(err)
+	a.Equal([]int{stored[2].ID, stored[1].ID}, res.EventIDs)
+	a.Equal([]int{stored[0].ID}, res.SkippedIDs)
+	a.Equal(models.MoneyFromInt(50), res.BalanceAfter)
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(models.MoneyFromInt(50), bal)
+
+	skipped, err := st.Get(ctx, stored[0].TransactionID)
+	a.NoError(err)
+	a.Equal(models.StatusProcessed, skipped.Status)
+}
+
+// Run history must keep outcome of every wallet and canceled events must link to their run
+func testCancellationRuns(t *testing.T, st eventsBackend, runs runsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	e := genTestEvent(w, models.MoneyFromInt(7))
+	a.NoError(st.Create(ctx, e))
+
+	strategy := cancellation.Last(1)
+	run := cancellation.Run{
+		Strategy:  strategy.Name(),
+		Params:    strategy.Params(),
+		StartedAt: time.Now().UTC(),
+	}
+	runID, err := runs.StartRun(ctx, run)
+	a.NoError(err)
+	run.ID = runID
+
+	res, err := st.CancelEvents(ctx, w, strategy, cancellation.Options{RunID: runID})
+	a.NoError(err)
+	failed := cancellation.Result{Wallet: models.Wallet{AccountID: w.AccountID, Currency: "USD"}, Err: errWalletNotFound}
+	run.Results = []cancellation.Result{res, failed}
+	run.Err = errors.New("Cancellation failed for 1 of 2 wallets")
+	run.FinishedAt = time.Now().UTC()
+	a.NoError(runs.FinishRun(ctx, run))
+
+	canceled, err := st.Get(ctx, e.TransactionID)
+	a.NoError(err)
+	if a.NotNil(canceled.CancellationRunID) {
+		a.Equal(runID, *canceled.CancellationRunID)
+	}
+
+	list, total, err := runs.ListRuns(ctx, 1, 0)
+	a.NoError(err)
+	a.True(total >= 1)
+	if !a.Len(list, 1) {
+		return
+	}
+	stored := list[0]
+	a.Equal(runID, stored.ID)
+	a.Equal(cancellation.NameLast, stored.Strategy)
+	a.EqualValues(1, stored.Params["number"])
+	a.False(stored.FinishedAt.IsZero())
+	a.EqualError(stored.Err, run.Err.Error())
+	if a.Len(stored.Results, 2) {
+		a.Equal(res.EventIDs, stored.Results[0].EventIDs)
+		a.Equal(models.MoneyFromInt(7), stored.Results[0].BalanceBefore)
+		a.Equal(models.Money(0), stored.Results[0].BalanceAfter)
+		a.NoError(stored.Results[0].Err)
+		a.EqualError(stored.Results[1].Err, errWalletNotFound.Error())
+	}
+}
+
+// Balance of one wallet must not be affected by events of another one
+func testBalancePerWallet(t *testing.T, st eventsBackend, createAccount createAccountFunc) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	richID, err := createAccount(ctx, "EUR", "JPY")
+	a.NoError(err)
+	poorID, err := createAccount(ctx, "EUR")
+	a.NoError(err)
+	richEUR := models.Wallet{AccountID: richID, Currency: "EUR"}
+	richJPY := models.Wallet{AccountID: richID, Currency: "JPY"}
+	poorEUR := models.Wallet{AccountID: poorID, Currency: "EUR"}
+
+	err = st.Create(ctx, genTestEvent(richEUR, models.MoneyFromInt(100)))
+	a.NoError(err)
+
+	err = st.Create(ctx, genTestEvent(poorEUR, models.MoneyFromInt(-10)))
+	a.Equal(errNegativeBalance, errors.Cause(err))
+
+	err = st.Create(ctx, genTestEvent(richJPY, models.MoneyFromInt(-10)))
+	a.Equal(errNegativeBalance, errors.Cause(err))
+
+	err = st.Create(ctx, genTestEvent(richEUR, models.MoneyFromInt(-10)))
+	a.NoError(err)
+
+	// account doesn't hold currency
+	err = st.Create(ctx, genTestEvent(models.Wallet{AccountID: poorID, Currency: "USD"}, models.MoneyFromInt(10)))
+	_, ok := errors.Cause(err).(*apperrors.BadRequest)
+	a.True(ok)
+
+	// JPY has no minor units
+	err = st.Create(ctx, genTestEvent(richJPY, models.Money(15000)))
+	a.Error(err)
+
+	richBal, err := st.Balance(ctx, richEUR)
+	a.NoError(err)
+	a.Equal(models.MoneyFromInt(90), richBal)
+
+	poorBal, err := st.Balance(ctx, poorEUR)
+	a.NoError(err)
+	a.Equal(models.Money(0), poorBal)
+
+	wallets, err := st.Wallets(ctx)
+	a.NoError(err)
+	a.Contains(wallets, richEUR)
+	a.Contains(wallets, richJPY)
+	a.Contains(wallets, poorEUR)
+}
+
+// Retried event must be applied only once
+func testIdempotentCreate(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	e := genTestEvent(w, models.MoneyFromInt(10))
+
+	a.NoError(st.Create(ctx, e))
+	a.NoError(st.Create(ctx, e))
+
+	changed := e
+	changed.Amount = models.MoneyFromInt(20)
+	err := st.Create(ctx, changed)
+	conflict, ok := errors.Cause(err).(*apperrors.Conflict)
+	if a.True(ok) {
+		a.Equal([]string{"Amount"}, conflict.Fields())
+	}
+
+	// retry from another source or with occurrence time differing from the stored one
+	changed = e
+	changed.SourceType = "other"
+	changed.OccurredAt = time.Now().Add(-time.Hour)
+	err = st.Create(ctx, changed)
+	conflict, ok = errors.Cause(err).(*apperrors.Conflict)
+	if a.True(ok) {
+		a.Equal([]string{"SourceType", "OccurredAt"}, conflict.Fields())
+	}
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(models.MoneyFromInt(10), bal)
+}
+
+func testGetAndList(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	started := time.Now()
+	created := make([]models.Event, 0, 5)
+	for i := 0; i < 5; i++ {
+		e := genTestEvent(w, models.MoneyFromInt(int64(i)+1))
+		e.SourceType = "game"
+		if i%2 == 1 {
+			e = genTestEvent(w, models.MoneyFromInt(-1))
+			e.SourceType = "server"
+		}
+		a.NoError(st.Create(ctx, e))
+		created = append(created, e)
+	}
+
+	stored, err := st.Get(ctx, created[0].TransactionID)
+	a.NoError(err)
+	a.Empty(stored.Diff(created[0]))
+	a.Equal("game", stored.SourceType)
+	a.Equal(models.StatusProcessed, stored.Status)
+
+	_, err = st.Get(ctx, "unknown transaction")
+	_, ok := errors.Cause(err).(*apperrors.NotFound)
+	a.True(ok)
+
+	events, total, err := st.List(ctx, models.EventFilter{AccountID: w.AccountID, Limit: 2})
+	a.NoError(err)
+	a.Equal(5, total)
+	if a.Len(events, 2) {
+		// newest first
+		a.Equal(created[4].TransactionID, events[0].TransactionID)
+		a.Equal(created[3].TransactionID, events[1].TransactionID)
+	}
+
+	events, total, err = st.List(ctx, models.EventFilter{AccountID: w.AccountID, OldestFirst: true, Limit: 2, Offset: 4})
+	a.NoError(err)
+	a.Equal(5, total)
+	if a.Len(events, 1) {
+		a.Equal(created[4].TransactionID, events[0].TransactionID)
+	}
+
+	events, total, err = st.List(ctx, models.EventFilter{
+		AccountID:  w.AccountID,
+		State:      models.StateLoss,
+		SourceType: "server",
+		From:       started.Add(-time.Minute),
+		To:         time.Now().Add(time.Minute),
+	})
+	a.NoError(err)
+	a.Equal(2, total)
+	a.Len(events, 2)
+
+	_, total, err = st.List(ctx, models.EventFilter{AccountID: w.AccountID, To: started.Add(-time.Minute)})
+	a.NoError(err)
+	a.Equal(0, total)
+}
+
+// Account holds zero balance in every currency it's opened with or given later, once
+func testAccounts(t *testing.T, accounts accountsBackend, createAccount createAccountFunc) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	accID, err := createAccount(ctx, "USD", "EUR")
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(accounts.AddCurrency(ctx, models.Wallet{AccountID: accID, Currency: "JPY"}))
+	balances, err := accounts.AccountBalances(ctx, accID)
+	a.NoError(err)
+	currencies := make([]models.Currency, 0, len(balances))
+	for _, b := range balances {
+		currencies = append(currencies, b.Currency)
+		a.Equal(models.Money(0), b.Total)
+	}
+	a.Equal([]models.Currency{"EUR", "JPY", "USD"}, currencies)
+
+	err = accounts.AddCurrency(ctx, models.Wallet{AccountID: accID, Currency: "EUR"})
+	a.IsType(&apperrors.Conflict{}, errors.Cause(err))
+	err = accounts.AddCurrency(ctx, models.Wallet{AccountID: accID + 1000, Currency: "EUR"})
+	a.IsType(&apperrors.NotFound{}, errors.Cause(err))
+	_, err = accounts.AccountBalances(ctx, accID+1000)
+	a.IsType(&apperrors.NotFound{}, errors.Cause(err))
+}
+
+// Metadata must be stored as is and filter events list
+func testMetadata(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	withMeta := genTestEvent(w, models.MoneyFromInt(10))
+	withMeta.Metadata = models.Metadata{
+		"roundId": "r-1",
+		"gameId":  42,
+		"bonus":   true,
+		"device":  map[string]interface{}{"os": "ios"},
+	}
+	other := genTestEvent(w, models.MoneyFromInt(5))
+	other.Metadata = models.Metadata{"roundId": "r-2", "gameId": "42"}
+	plain := genTestEvent(w, models.MoneyFromInt(1))
+	for _, e := range []models.Event{withMeta, other, plain} {
+		a.NoError(st.Create(ctx, e))
+	}
+
+	stored, err := st.Get(ctx, withMeta.TransactionID)
+	a.NoError(err)
+	a.True(withMeta.Metadata.Equal(stored.Metadata), "%v", stored.Metadata)
+	stored, err = st.Get(ctx, plain.TransactionID)
+	a.NoError(err)
+	a.Empty(stored.Metadata)
+
+	changed := withMeta
+	changed.Metadata = models.Metadata{"roundId": "r-3"}
+	err = st.Create(ctx, changed)
+	conflict, ok := errors.Cause(err).(*apperrors.Conflict)
+	if a.True(ok) {
+		a.Equal([]string{"Metadata"}, conflict.Fields())
+	}
+
+	cases := []struct {
+		filter   map[string]string
+		expected int
+	}{
+		{map[string]string{"roundId": "r-1"}, 1},
+		{map[string]string{"gameId": "42"}, 2},
+		{map[string]string{"gameId": "42", "roundId": "r-2"}, 1},
+		{map[string]string{"bonus": "true"}, 1},
+		{map[string]string{"device.os": "ios"}, 1},
+		{map[string]string{"device.os": "android"}, 0},
+		{map[string]string{"device": "ios"}, 0},
+		{map[string]string{"missing": "r-1"}, 0},
+	}
+	for _, c := range cases {
+		_, total, err := st.List(ctx, models.EventFilter{AccountID: w.AccountID, Metadata: c.filter})
+		a.NoError(err)
+		a.Equal(c.expected, total, "%v", c.filter)
+	}
+}
+
+// Events replayed out of order must be listed and canceled by occurrence time when asked
+func testOccurrenceTime(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	// ingested in order 0, 1, 2 but occurred in order 1, 2, 0
+	occurred := []time.Time{now.Add(-time.Minute), now.Add(-3 * time.Hour), now.Add(-2 * time.Hour)}
+	created := make([]models.Event, 0, len(occurred))
+	for _, at := range occurred {
+		e := genTestEvent(w, models.MoneyFromInt(10))
+		e.OccurredAt = at
+		a.NoError(st.Create(ctx, e))
+		created = append(created, e)
+	}
+	plain := genTestEvent(w, models.MoneyFromInt(10))
+	a.NoError(st.Create(ctx, plain))
+
+	stored, err := st.Get(ctx, created[1].TransactionID)
+	a.NoError(err)
+	a.WithinDuration(occurred[1], stored.OccurredAt, time.Millisecond)
+	stored, err = st.Get(ctx, plain.TransactionID)
+	a.NoError(err)
+	a.Equal(stored.CreatedAt, stored.OccurredAt)
+
+	moved := created[0]
+	moved.OccurredAt = now.Add(-time.Hour)
+	err = st.Create(ctx, moved)
+	conflict, ok := errors.Cause(err).(*apperrors.Conflict)
+	if a.True(ok) {
+		a.Equal([]string{"OccurredAt"}, conflict.Fields())
+	}
+
+	ids := func(events []models.Event) []string {
+		res := make([]string, 0, len(events))
+		for _, e := range events {
+			res = append(res, e.TransactionID)
+		}
+		return res
+	}
+	events, _, err := st.List(ctx, models.EventFilter{AccountID: w.AccountID, Time: models.OccurrenceTime, OldestFirst: true})
+	a.NoError(err)
+	a.Equal([]string{created[1].TransactionID, created[2].TransactionID, created[0].TransactionID, plain.TransactionID},
+		ids(events))
+
+	events, total, err := st.List(ctx, models.EventFilter{
+		AccountID: w.AccountID,
+		Time:      models.OccurrenceTime,
+		To:        now.Add(-time.Hour),
+	})
+	a.NoError(err)
+	a.Equal(2, total)
+	a.Equal([]string{created[2].TransactionID, created[1].TransactionID}, ids(events))
+
+	// the latest to occur of events which occurred more than an hour ago
+	res, err := st.CancelEvents(ctx, w, cancellation.OrderedBy(cancellation.OlderThan(time.Hour, 1), models.OccurrenceTime),
+		cancellation.Options{})
+	a.NoError(err)
+	if a.Len(res.EventIDs, 1) {
+		canceled, err := st.Get(ctx, created[2].TransactionID)
+		a.NoError(err)
+		a.Equal(canceled.ID, res.EventIDs[0])
+	}
+}
+
+// Every change must be written to outbox in order, consumers move through it on their own
+func testOutbox(t *testing.T, st eventsBackend, messages outboxBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+	// consumers are known before the changes
+	for _, consumer := range []string{"test-outbox", "test-outbox-other"} {
+		_, err := messages.Pending(ctx, consumer, 0)
+		a.NoError(err)
+	}
+
+	win := genTestEvent(w, models.MoneyFromInt(10))
+	loss := genTestEvent(w, models.MoneyFromInt(-3))
+	a.NoError(st.Create(ctx, win))
+	a.NoError(st.Create(ctx, loss))
+	// rejected and repeated events change nothing
+	a.Error(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(-100))))
+	a.NoError(st.Create(ctx, win))
+	_, err := st.CancelEvents(ctx, w, cancellation.Last(1), cancellation.Options{DryRun: true})
+	a.NoError(err)
+	_, err = st.CancelEvents(ctx, w, cancellation.Last(1), cancellation.Options{})
+	a.NoError(err)
+
+	// messages of other cases may be pending too
+	walletMessages := func(consumer string) []outbox.Message {
+		pending, err := messages.Pending(ctx, consumer, 0)
+		a.NoError(err)
+		var res []outbox.Message
+		for _, m := range pending {
+			var p struct {
+				AccountID int `json:"accountId"`
+			}
+			a.NoError(json.Unmarshal(m.Payload, &p))
+			if p.AccountID == w.AccountID {
+				res = append(res, m)
+			}
+		}
+		return res
+	}
+	pending := walletMessages("test-outbox")
+	topics := make([]string, 0, len(pending))
+	keys := make(map[string]bool)
+	for i, m := range pending {
+		topics = append(topics, m.Topic)
+		keys[m.Key] = true
+		if i > 0 {
+			a.True(pending[i-1].ID < m.ID)
+		}
+	}
+	a.Equal([]string{
+		outbox.TopicEventCreated, outbox.TopicBalanceChanged,
+		outbox.TopicEventCreated, outbox.TopicBalanceChanged,
+		outbox.TopicEventCanceled, outbox.TopicBalanceChanged,
+	}, topics)
+	a.Len(keys, len(pending))
+
+	if len(pending) == 6 {
+		var canceled outbox.EventPayload
+		a.NoError(json.Unmarshal(pending[4].Payload, &canceled))
+		a.Equal(loss.TransactionID, canceled.TransactionID)
+		a.Equal("canceled", canceled.Status)
+
+		var bal outbox.BalancePayload
+		a.NoError(json.Unmarshal(pending[5].Payload, &bal))
+		a.Equal(models.MoneyFromInt(10), bal.Total)
+		a.Equal(int64(3), bal.Version)
+	}
+
+	ids := make([]int64, 0, len(pending))
+	for _, m := range pending {
+		ids = append(ids, m.ID)
+	}
+	a.NoError(messages.MarkPublished(ctx, "test-outbox", ids))
+	a.Empty(walletMessages("test-outbox"))
+	a.Equal(pending, walletMessages("test-outbox-other"))
+}
+
+// Run canceling nothing leaves balance version and outbox as they are
+func testEmptyCancellation(t *testing.T, st eventsBackend, accounts accountsBackend, messages outboxBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	a.NoError(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(10))))
+	before, err := accounts.AccountBalances(ctx, w.AccountID)
+	if !a.NoError(err) || !a.Len(before, 1) {
+		return
+	}
+	pending, err := messages.Pending(ctx, "test-empty-cancellation", 0)
+	a.NoError(err)
+
+	res, err := st.CancelEvents(ctx, w, cancellation.BySourceType("unknown", 10), cancellation.Options{})
+	a.NoError(err)
+	a.Empty(res.EventIDs)
+
+	after, err := accounts.AccountBalances(ctx, w.AccountID)
+	a.NoError(err)
+	a.Equal(before, after)
+	stillPending, err := messages.Pending(ctx, "test-empty-cancellation", 0)
+	a.NoError(err)
+	a.Equal(pending, stillPending)
+}
+
+// Every balance change must bump its version
+func testBalanceVersion(t *testing.T, st eventsBackend, accounts accountsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	balances, err := accounts.AccountBalances(ctx, w.AccountID)
+	a.NoError(err)
+	if !a.Len(balances, 1) {
+		return
+	}
+	a.Equal(w, balances[0].Wallet)
+	a.Equal(models.Money(0), balances[0].Total)
+	initial := balances[0]
+
+	a.NoError(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(7))))
+	a.Error(st.Create(ctx, genTestEvent(w, models.MoneyFromInt(-8))))
+
+	balances, err = accounts.AccountBalances(ctx, w.AccountID)
+	a.NoError(err)
+	if a.Len(balances, 1) {
+		a.Equal(models.MoneyFromInt(7), balances[0].Total)
+		a.Equal(initial.Version+1, balances[0].Version)
+		a.False(balances[0].UpdatedAt.Before(initial.UpdatedAt))
+	}
+
+	_, err = accounts.AccountBalances(ctx, w.AccountID+1000000)
+	_, ok := errors.Cause(err).(*apperrors.NotFound)
+	a.True(ok)
+}
+
+// Failed events of independent batch don't stop the others
+func testIndependentBatch(t *testing.T, st eventsBackend, accounts accountsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	stored := genTestEvent(w, models.MoneyFromInt(10))
+	a.NoError(st.Create(ctx, stored))
+	initial, err := accounts.AccountBalances(ctx, w.AccountID)
+	if !a.NoError(err) || !a.Len(initial, 1) {
+		return
+	}
+	conflicting := stored
+	conflicting.Amount = models.MoneyFromInt(20)
+	first := genTestEvent(w, models.MoneyFromInt(5))
+	invalid := genTestEvent(w, models.MoneyFromInt(-1))
+	invalid.State = models.StateWin
+	unknownWallet := genTestEvent(models.Wallet{AccountID: w.AccountID, Currency: "USD"}, models.MoneyFromInt(1))
+
+	results, err := st.CreateBatch(ctx, []models.Event{
+		first,
+		stored,
+		conflicting,
+		genTestEvent(w, models.MoneyFromInt(-16)),
+		invalid,
+		unknownWallet,
+		genTestEvent(w, models.MoneyFromInt(-15)),
+		first,
+	}, false)
+	a.NoError(err)
+	var statuses []models.BatchStatus
+	for _, r := range results {
+		statuses = append(statuses, r.Status)
+	}
+	a.Equal([]models.BatchStatus{
+		models.BatchCreated,
+		models.BatchDuplicate,
+		models.BatchConflict,
+		models.BatchInsufficientBalance,
+		models.BatchInvalid,
+		models.BatchInvalid,
+		models.BatchCreated,
+		models.BatchDuplicate,
+	}, statuses)
+	if a.Len(results, 8) {
+		conflict, ok := errors.Cause(results[2].Err).(*apperrors.Conflict)
+		if a.True(ok) {
+			a.Equal([]string{"Amount"}, conflict.Fields())
+		}
+	}
+
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(models.Money(0), bal)
+	_, err = st.Get(ctx, first.TransactionID)
+	a.NoError(err)
+
+	// one balance version for the whole batch
+	balances, err := accounts.AccountBalances(ctx, w.AccountID)
+	a.NoError(err)
+	if a.Len(balances, 1) {
+		a.Equal(initial[0].Version+1, balances[0].Version)
+	}
+}
+
+// Atomic batch stores nothing when any event fails
+func testAtomicBatch(t *testing.T, st eventsBackend, w models.Wallet) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	stored := genTestEvent(w, models.MoneyFromInt(10))
+	a.NoError(st.Create(ctx, stored))
+	win := genTestEvent(w, models.MoneyFromInt(5))
+
+	results, err := st.CreateBatch(ctx, []models.Event{
+		win,
+		stored,
+		genTestEvent(w, models.MoneyFromInt(-20)),
+	}, true)
+	a.NoError(err)
+	if a.Len(results, 3) {
+		a.Equal(models.BatchAborted, results[0].Status)
+		a.Equal(models.BatchDuplicate, results[1].Status)
+		a.Equal(models.BatchInsufficientBalance, results[2].Status)
+	}
+	_, err = st.Get(ctx, win.TransactionID)
+	_, ok := errors.Cause(err).(*apperrors.NotFound)
+	a.True(ok)
+
+	loss := genTestEvent(w, models.MoneyFromInt(-15))
+	results, err = st.CreateBatch(ctx, []models.Event{win, stored, loss}, true)
+	a.NoError(err)
+	if a.Len(results, 3) {
+		a.Equal(models.BatchCreated, results[0].Status)
+		a.Equal(models.BatchDuplicate, results[1].Status)
+		a.Equal(models.BatchCreated, results[2].Status)
+	}
+	bal, err := st.Balance(ctx, w)
+	a.NoError(err)
+	a.Equal(models.Money(0), bal)
+}
+
+func genTestEvent(w models.Wallet, amount models.Money) models.Event {
+	u := uuid.New()
+	var state models.EventState
+	if amount > 0 {
+		state = models.StateWin
+	} else {
+		state = models.StateLoss
+	}
+	return models.Event{
+		AccountID:     w.AccountID,
+		State:         state,
+		Amount:        amount,
+		Currency:      w.Currency,
+		Status:        models.StatusProcessed,
+		TransactionID: u.String(),
+	}
+}
+
+// testWebhooksConformance checks webhook deliveries go through retries, dead letters and replay
+func testWebhooksConformance(t *testing.T, st webhooksBackend) {
+	a := assert.New(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	wins, err := st.CreateEndpoint(ctx, webhooks.Endpoint{
+		URL:    "https://example.com/wins",
+		Secret: "s1",
+		States: []models.EventState{models.StateWin},
+	})
+	a.NoError(err)
+	all, err := st.CreateEndpoint(ctx, webhooks.Endpoint{URL: "https://example.com/all", Secret: "s2"})
+	a.NoError(err)
+	a.NotEqual(wins.ID, all.ID)
+	endpoints, err := st.Endpoints(ctx)
+	a.NoError(err)
+	if a.Len(endpoints, 2) {
+		a.Equal(wins.ID, endpoints[0].ID)
+		a.Equal([]models.EventState{models.StateWin}, endpoints[0].States)
+		a.Equal("s2", endpoints[1].Secret)
+	}
+
+	key := "event.created:" + uuid.New().String()
+	delivery := func(endpointID int) webhooks.Delivery {
+		return webhooks.Delivery{
+			EndpointID:    endpointID,
+			Key:           key,
+			Topic:         outbox.TopicEventCreated,
+			Payload:       json.RawMessage(`{"id": 1}`),
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		}
+	}
+	a.NoError(st.EnqueueDeliveries(ctx, []webhooks.Delivery{delivery(wins.ID), delivery(all.ID)}))
+	// repeated message is queued once
+	a.NoError(st.EnqueueDeliveries(ctx, []webhooks.Delivery{delivery(wins.ID)}))
+
+	due := func(at time.Time) map[int]webhooks.Delivery {
+		list, err := st.DueDeliveries(ctx, at, 0)
+		a.NoError(err)
+		res := make(map[int]webhooks.Delivery)
+		for _, d := range list {
+			_, dup := res[d.EndpointID]
+			a.False(dup)
+			res[d.EndpointID] = d
+		}
+		return res
+	}
+	queued := due(now)
+	if !a.Len(queued, 2) {
+		return
+	}
+	first := queued[wins.ID]
+	a.Equal(key, first.Key)
+	a.JSONEq(`{"id": 1}`, string(first.Payload))
+
+	a.NoError(st.RetryDelivery(ctx, first.ID, now.Add(time.Hour), "status 500"))
+	a.NoError(st.MarkDelivered(ctx, queued[all.ID].ID, now))
+	a.Empty(due(now))
+	retried := due(now.Add(2 * time.Hour))
+	if a.Len(retried, 1) {
+		a.Equal(1, retried[wins.ID].Attempts)
+		a.Equal("status 500", retried[wins.ID].LastError)
+	}
+
+	a.NoError(st.BuryDelivery(ctx, first.ID, now, "timeout"))
+	a.Empty(due(now.Add(2 * time.Hour)))
+	letters, total, err := st.DeadLetters(ctx, wins.ID, 10, 0)
+	a.NoError(err)
+	a.Equal(1, total)
+	if a.Len(letters, 1) {
+		a.Equal(first.ID, letters[0].ID)
+		a.Equal(2, letters[0].Attempts)
+		a.Equal("timeout", letters[0].LastError)
+	}
+	_, total, err = st.DeadLetters(ctx, all.ID, 10, 0)
+	a.NoError(err)
+	a.Equal(0, total)
+
+	n, err := st.ReplayDeadLetters(ctx, wins.ID, nil, now)
+	a.NoError(err)
+	a.Equal(1, n)
+	_, total, err = st.DeadLetters(ctx, 0, 10, 0)
+	a.NoError(err)
+	a.Equal(0, total)
+	replayed := due(now)
+	if a.Len(replayed, 1) {
+		a.Equal(first.ID, replayed[wins.ID].ID)
+		a.Equal(0, replayed[wins.ID].Attempts)
+	}
+
+	a.NoError(st.DeleteEndpoint(ctx, wins.ID))
+	a.Empty(due(now))
+	err = st.DeleteEndpoint(ctx, wins.ID)
+	_, ok := errors.Cause(err).(*apperrors.NotFound)
+	a.True(ok)
+
+	// endpoint failing in a row is disabled and keeps its deliveries till enabled
+	key = "event.created:" + uuid.New().String()
+	a.NoError(st.EnqueueDeliveries(ctx, []webhooks.Delivery{delivery(all.ID)}))
+	disabled, err := st.EndpointFailed(ctx, all.ID, now, 2)
+	a.NoError(err)
+	a.False(disabled)
+	a.NoError(st.EndpointSucceeded(ctx, all.ID))
+	for i := 0; i < 2; i++ {
+		disabled, err = st.EndpointFailed(ctx, all.ID, now, 2)
+		a.NoError(err)
+	}
+	a.True(disabled)
+	disabled, err = st.EndpointFailed(ctx, all.ID, now, 2)
+	a.NoError(err)
+	a.False(disabled, "disabled endpoint is disabled once")
+	a.Empty(due(now))
+	endpoints, err = st.Endpoints(ctx)
+	a.NoError(err)
+	if a.Len(endpoints, 1) {
+		a.Equal(3, endpoints[0].Failures)
+		a.NotNil(endpoints[0].DisabledAt)
+	}
+	a.NoError(st.EnableEndpoint(ctx, all.ID))
+	a.Len(due(now), 1)
+	endpoints, err = st.Endpoints(ctx)
+	a.NoError(err)
+	if a.Len(endpoints, 1) {
+		a.Equal(0, endpoints[0].Failures)
+		a.Nil(endpoints[0].DisabledAt)
+	}
+	err = st.EnableEndpoint(ctx, wins.ID)
+	_, ok = errors.Cause(err).(*apperrors.NotFound)
+	a.True(ok)
+
+	// limit applies to every endpoint, so a long queue doesn't hold up the others
+	other, err := st.CreateEndpoint(ctx, webhooks.Endpoint{URL: "https://example.com/other", Secret: "s3"})
+	a.NoError(err)
+	a.NoError(st.EnqueueDeliveries(ctx, []webhooks.Delivery{delivery(other.ID)}))
+	key = "event.created:" + uuid.New().String()
+	a.NoError(st.EnqueueDeliveries(ctx, []webhooks.Delivery{delivery(all.ID)}))
+	limited, err := st.DueDeliveries(ctx, now, 1)
+	a.NoError(err)
+	if a.Len(limited, 2) {
+		a.ElementsMatch([]int{all.ID, other.ID}, []int{limited[0].EndpointID, limited[1].EndpointID})
+	}
+}
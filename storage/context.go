@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// beginTx starts transaction bound to the context, database/sql rolls it back as soon as the context is done.
+// A statement still running then isn't interrupted, so statements are limited by the deadline on Postgres side.
+func beginTx(ctx context.Context, db *gorm.DB) (*gorm.DB, error) {
+	tx := db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return tx, nil
+	}
+	// statement_timeout of zero would disable it
+	ms := int64(time.Until(deadline)/time.Millisecond) + 1
+	if ms < 1 {
+		ms = 1
+	}
+	if err := tx.Exec("SELECT set_config('statement_timeout', ?, true)", ms).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "can't limit statements by deadline")
+	}
+	return tx, nil
+}
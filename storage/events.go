@@ -2,144 +2,618 @@ package storage
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/cancellation"
 	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
 	"github.com/jinzhu/gorm"
 )
 
 type events struct {
 	db *gorm.DB
+	// balance changes run serializable without explicit row locks,
+	// conflicting transactions are retried
+	txCreate txOptions
+	txCancel txOptions
 }
 
 // NewEvents returns Events storage
 func NewEvents(db *gorm.DB) *events {
 	return &events{
-		db: db,
+		db:       db,
+		txCreate: serializableTx,
+		txCancel: serializableTx,
 	}
 }
 
 var (
-	errNegativeBalance = errors.New("Balance cannot be negative")
-	errCancellation    = errors.New("Cannot cancel last events due to low balance")
+	errNegativeBalance  = errors.New("Balance cannot be negative")
+	errBalanceTooLarge  = errors.New("Balance cannot exceed 99999999999999.9999")
+	errInvalidAmount    = errors.New("Invalid amount")
+	errCancellation     = errors.New("Cannot cancel last events due to low balance")
+	errWalletNotFound   = errors.New("Account does not hold this currency")
+	errDuplicate        = errors.New("Transaction was already processed with different data")
+	errEventNotFound    = errors.New("Event not found")
+	errAccountNotFound  = errors.New("Account not found")
+	errEndpointNotFound = errors.New("Webhook endpoint not found")
+	errCurrencyHeld     = errors.New("Account already holds this currency")
 )
 
-type balance struct {
-	Total float64
+const transactionIDIndex = "events_transaction_id_uindex"
+
+func onDuplicateTransactionID(err error) bool {
+	return isUniqueViolation(err, transactionIDIndex)
 }
 
-type orderedEvent struct {
-	models.Event
-	RowNumber int
+type balance struct {
+	Total models.Money
 }
 
-// Create is for adding new event
+// Create is for adding new event.
+// Repeated event with the same transaction ID is a no-op, unless its data differs.
 func (s *events) Create(ctx context.Context, e models.Event) error {
 	if err := validateEventAmount(e); err != nil {
 		return errors.WithStack(err)
 	}
-	err := withTransaction(s.db, func(tx *gorm.DB) error {
-		bal, err := getBalanceWithLock(ctx, tx)
+	err := withTransaction(ctx, s.db, s.txCreate, func(tx *gorm.DB) error {
+		// keep original event untouched between retries
+		e := e
+		bal, err := getBalance(ctx, tx, e.Wallet(), needsLock(s.txCreate))
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		stored, err := getEventByTransactionID(ctx, tx, e.TransactionID)
+		if err == nil {
+			return checkDuplicate(stored, e)
+		}
+		if !gorm.IsRecordNotFoundError(errors.Cause(err)) {
+			return errors.WithStack(err)
+		}
 		totalBal := bal + e.Amount
 		if totalBal < 0 {
 			return errors.WithStack(errNegativeBalance)
 		}
+		e.CreatedAt = time.Now().UTC()
+		e.OccurredAt = occurredAt(e)
 		if err := tx.Create(&e).Error; err != nil {
 			return errors.WithStack(err)
 		}
-		if err := setBalance(ctx, tx, totalBal); err != nil {
+		if err := postEvent(ctx, tx, e); err != nil {
 			return errors.WithStack(err)
 		}
-		return nil
+		b, err := setBalance(ctx, tx, e.Wallet(), totalBal)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return writeOutbox(ctx, tx, outbox.EventCreated(e), outbox.BalanceChanged(b))
 	})
+	// concurrent duplicate from another wallet wasn't serialized by balance lock
+	if isUniqueViolation(err, transactionIDIndex) {
+		var stored models.Event
+		err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+			var err error
+			stored, err = getEventByTransactionID(ctx, tx, e.TransactionID)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "Storage error while creating event")
+		}
+		return checkDuplicate(stored, e)
+	}
 	return errors.Wrap(err, "Storage error while creating event")
 }
 
-// CancelLastOddEvents cancel last odd given events and recalculate balance
-func (s *events) CancelLastOddEvents(ctx context.Context, num int) error {
-	err := withTransaction(s.db, func(tx *gorm.DB) error {
-		bal, err := getBalanceWithLock(ctx, tx)
+// CreateBatch adds events in one transaction taking every wallet once, with one balance version per wallet.
+// Atomic batch stores nothing unless every event is created or duplicate,
+// otherwise failed events don't affect the others.
+func (s *events) CreateBatch(ctx context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	var results []models.BatchResult
+	wallets := batchWallets(events)
+	// concurrent duplicate from another wallet isn't serialized by balance locks,
+	// the next attempt finds it stored
+	opts := s.txCreate
+	opts.retry = opts.retry.orOn(onDuplicateTransactionID)
+	err := withTransaction(ctx, s.db, opts, func(tx *gorm.DB) error {
+		balances, err := getBalances(ctx, tx, wallets, needsLock(s.txCreate))
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		events, err := getLastOrderedEvents(ctx, tx, num*2)
+		stored, err := getEventsByTransactionIDs(ctx, tx, batchTransactionIDs(events))
 		if err != nil {
-			return errors.Wrap(err, "Cannot get last events")
+			return errors.WithStack(err)
 		}
-		var canBal float64
-		cancelIDs := make([]int, 0, num)
-		for _, e := range events {
-			// skip already canceled and EVEN records
-			if e.Status == models.StatusCanceled || e.RowNumber%2 == 0 {
+		results = planBatch(events, stored, balances)
+		if atomic && models.BatchFailed(results) {
+			models.AbortBatch(results)
+			return nil
+		}
+		var messages []outbox.Message
+		changed := make(map[models.Wallet]bool)
+		now := time.Now().UTC()
+		for i, e := range events {
+			if results[i].Status != models.BatchCreated {
 				continue
 			}
+			e.CreatedAt = now
+			e.OccurredAt = occurredAt(e)
+			if err := tx.Create(&e).Error; err != nil {
+				return errors.WithStack(err)
+			}
+			if err := postEvent(ctx, tx, e); err != nil {
+				return errors.WithStack(err)
+			}
+			messages = append(messages, outbox.EventCreated(e))
+			changed[e.Wallet()] = true
+		}
+		for _, w := range wallets {
+			if !changed[w] {
+				continue
+			}
+			b, err := setBalance(ctx, tx, w, balances[w])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			messages = append(messages, outbox.BalanceChanged(b))
+		}
+		return writeOutbox(ctx, tx, messages...)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Storage error while creating events")
+	}
+	return results, nil
+}
+
+// CancelEvents cancels events of the wallet chosen by the strategy and recalculates its balance.
+// Dry run only computes what would be canceled,
+// partial run skips events which would make balance negative.
+func (s *events) CancelEvents(ctx context.Context, w models.Wallet, strategy cancellation.Strategy,
+	opts cancellation.Options) (cancellation.Result, error) {
+	var res cancellation.Result
+	err := withTransaction(ctx, s.db, s.txCancel, func(tx *gorm.DB) error {
+		res = cancellation.Result{Wallet: w, DryRun: opts.DryRun}
+		bal, err := getBalance(ctx, tx, w, needsLock(s.txCancel) && !opts.DryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		candidates, total, err := getCandidateEvents(ctx, tx, strategy.Candidates(w, time.Now().UTC()))
+		if err != nil {
+			return errors.Wrap(err, "Cannot get candidate events")
+		}
+		canceled := strategy.Select(candidates, total)
+		if opts.Partial {
+			var skipped []models.Event
+			canceled, skipped = cancellation.Fit(canceled, bal)
+			for _, e := range skipped {
+				res.SkippedIDs = append(res.SkippedIDs, e.ID)
+			}
+		}
+		var canBal models.Money
+		res.EventIDs = make([]int, 0, len(canceled))
+		for _, e := range canceled {
 			canBal -= e.Amount
-			cancelIDs = append(cancelIDs, e.ID)
+			res.EventIDs = append(res.EventIDs, e.ID)
 		}
-		totalBal := bal + canBal
-		if totalBal < 0 {
+		res.BalanceBefore, res.BalanceAfter = bal, bal+canBal
+		if res.BalanceAfter < 0 {
 			return errors.WithStack(errNegativeBalance)
 		}
-		if err = cancelEventsByIDs(ctx, tx, cancelIDs); err != nil {
+		// nothing changes, balance keeps its version
+		if opts.DryRun || len(res.EventIDs) == 0 {
+			return nil
+		}
+		if err = cancelEventsByIDs(ctx, tx, res.EventIDs, opts.RunID); err != nil {
 			return errors.WithStack(errCancellation)
 		}
-		if err := setBalance(ctx, tx, totalBal); err != nil {
+		messages := make([]outbox.Message, 0, len(canceled)+1)
+		for _, e := range canceled {
+			if err := postCancellation(ctx, tx, e); err != nil {
+				return errors.WithStack(err)
+			}
+			e.CancellationRunID = runIDRef(opts.RunID)
+			messages = append(messages, outbox.EventCanceled(e, time.Now().UTC()))
+		}
+		b, err := setBalance(ctx, tx, w, res.BalanceAfter)
+		if err != nil {
 			return errors.WithStack(err)
 		}
-		return nil
+		return writeOutbox(ctx, tx, append(messages, outbox.BalanceChanged(b))...)
 	})
-	return errors.Wrap(err, "Canceling events error")
+	return res, errors.Wrapf(err, "Canceling events error for account %d in %s", w.AccountID, w.Currency)
 }
 
-func getLastOrderedEvents(_ context.Context, tx *gorm.DB, num int) ([]orderedEvent, error) {
-	var events []orderedEvent
-	err := tx.Raw(`
-			SELECT *, ROW_NUMBER () OVER (ORDER BY id)
-			FROM events ORDER BY id DESC LIMIT ?`, num).
-		Find(&events).Error
+// RecomputeBalance rebuilds cached wallet balance from the ledger
+func (s *events) RecomputeBalance(ctx context.Context, w models.Wallet) (models.Money, error) {
+	var total models.Money
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		if _, err := getBalanceWithLock(ctx, tx, w); err != nil {
+			return errors.WithStack(err)
+		}
+		var err error
+		total, err = getLedgerBalance(ctx, tx, w)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		b, err := setBalance(ctx, tx, w, total)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return writeOutbox(ctx, tx, outbox.BalanceChanged(b))
+	})
 	if err != nil {
+		return 0, errors.Wrap(err, "Storage error while recomputing balance")
+	}
+	return total, nil
+}
+
+// Get returns event by its transaction ID
+func (s *events) Get(ctx context.Context, transactionID string) (models.Event, error) {
+	var e models.Event
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		var err error
+		e, err = getEventByTransactionID(ctx, tx, transactionID)
+		return err
+	})
+	if gorm.IsRecordNotFoundError(errors.Cause(err)) {
+		return e, errors.WithStack(apperrors.NewNotFound(errEventNotFound))
+	}
+	return e, errors.Wrap(err, "Storage error while getting event")
+}
+
+// List returns page of events matching the filter and total number of matching events
+func (s *events) List(ctx context.Context, f models.EventFilter) ([]models.Event, int, error) {
+	var (
+		events []models.Event
+		total  int
+	)
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		q := filterEvents(tx.Model(&models.Event{}), f)
+		if err := q.Count(&total).Error; err != nil {
+			return errors.Wrap(err, "Storage error while counting events")
+		}
+		dir := " DESC"
+		if f.OldestFirst {
+			dir = " ASC"
+		}
+		q = q.Order(timeColumn(f.Time) + dir).Order("id" + dir)
+		if f.Limit > 0 {
+			q = q.Limit(f.Limit)
+		}
+		if f.Offset > 0 {
+			q = q.Offset(f.Offset)
+		}
+		events = []models.Event{}
+		return errors.Wrap(q.Find(&events).Error, "Storage error while listing events")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// Balance returns current wallet balance
+func (s *events) Balance(ctx context.Context, w models.Wallet) (models.Money, error) {
+	var bal models.Money
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		var err error
+		bal, err = getBalance(ctx, tx, w, false)
+		return err
+	})
+	return bal, errors.WithStack(err)
+}
+
+// Wallets returns all existing account balances
+func (s *events) Wallets(ctx context.Context) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Raw("SELECT account_id, currency FROM balance ORDER BY account_id, currency").
+			Scan(&wallets).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get wallets")
+	}
+	return wallets, nil
+}
+
+// getCandidateEvents lists events matching the filter in ingestion order or by occurrence time,
+// newest first unless the filter says otherwise, and counts all of them
+func getCandidateEvents(ctx context.Context, tx *gorm.DB, f models.EventFilter) ([]models.Event, int, error) {
+	q := filterEvents(tx.Model(&models.Event{}), f)
+	var total int
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	dir := " DESC"
+	if f.OldestFirst {
+		dir = " ASC"
+	}
+	// serial id follows ingestion order
+	if f.Time == models.OccurrenceTime {
+		q = q.Order("occurred_at" + dir)
+	}
+	q = q.Order("id" + dir)
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	var events []models.Event
+	if err := q.Find(&events).Error; err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	return events, total, nil
+}
+
+func filterEvents(q *gorm.DB, f models.EventFilter) *gorm.DB {
+	if f.AccountID != 0 {
+		q = q.Where("account_id = ?", f.AccountID)
+	}
+	if f.Currency != "" {
+		q = q.Where("currency = ?", f.Currency)
+	}
+	if f.State != "" {
+		q = q.Where("state = ?", f.State)
+	}
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.SourceType != "" {
+		q = q.Where("source_type = ?", f.SourceType)
+	}
+	if !f.From.IsZero() {
+		q = q.Where(timeColumn(f.Time)+" >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		q = q.Where(timeColumn(f.Time)+" < ?", f.To.UTC())
+	}
+	for path, value := range f.Metadata {
+		q = filterMetadata(q, path, value)
+	}
+	return q
+}
+
+// filterMetadata matches value at the path by containment, which events_metadata_index supports
+func filterMetadata(q *gorm.DB, path, value string) *gorm.DB {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, v := range models.MetadataValues(value) {
+		m, err := models.MetadataAt(path, v).Value()
+		if err != nil {
+			return q.Where("false")
+		}
+		conds = append(conds, "metadata @> ?")
+		args = append(args, m)
+	}
+	return q.Where("("+strings.Join(conds, " OR ")+")", args...)
+}
+
+func timeColumn(t models.EventTime) string {
+	if t == models.OccurrenceTime {
+		return "occurred_at"
+	}
+	return "created_at"
+}
+
+// occurredAt returns occurrence time to store, ingestion time if the source didn't report it
+func occurredAt(e models.Event) time.Time {
+	if e.OccurredAt.IsZero() {
+		return e.CreatedAt
+	}
+	return e.OccurredAt.UTC()
+}
+
+func getEventByTransactionID(ctx context.Context, tx *gorm.DB, transactionID string) (models.Event, error) {
+	var e models.Event
+	err := tx.Where("transaction_id = ?", transactionID).First(&e).Error
+	return e, errors.WithStack(err)
+}
+
+// checkDuplicate allows retry of already stored event only with the same payload
+func checkDuplicate(stored, e models.Event) error {
+	if diff := stored.Diff(e); len(diff) > 0 {
+		return errors.WithStack(apperrors.NewConflict(errDuplicate, diff))
+	}
+	return nil
+}
+
+func getEventsByTransactionIDs(ctx context.Context, tx *gorm.DB, transactionIDs []string) (map[string]models.Event, error) {
+	var events []models.Event
+	if err := tx.Where("transaction_id IN (?)", transactionIDs).Find(&events).Error; err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return events, nil
+	stored := make(map[string]models.Event, len(events))
+	for _, e := range events {
+		stored[e.TransactionID] = e
+	}
+	return stored, nil
+}
+
+// planBatch decides outcome of every event of the batch in order, given the events stored
+// with the same transaction IDs and balances of the existing wallets, which it moves by created events
+func planBatch(events []models.Event, stored map[string]models.Event,
+	balances map[models.Wallet]models.Money) []models.BatchResult {
+	results := make([]models.BatchResult, len(events))
+	// created are events of the batch to be created, by transaction ID
+	created := make(map[string]models.Event)
+	for i, e := range events {
+		results[i] = planEvent(e, stored, created, balances)
+	}
+	return results
 }
 
-func cancelEventsByIDs(_ context.Context, tx *gorm.DB, ids []int) error {
+func planEvent(e models.Event, stored, created map[string]models.Event,
+	balances map[models.Wallet]models.Money) models.BatchResult {
+	if err := validateEventAmount(e); err != nil {
+		return models.BatchResult{Status: models.BatchInvalid, Err: err}
+	}
+	prev, ok := stored[e.TransactionID]
+	if !ok {
+		prev, ok = created[e.TransactionID]
+	}
+	if ok {
+		if err := checkDuplicate(prev, e); err != nil {
+			return models.BatchResult{Status: models.BatchConflict, Err: errors.Cause(err)}
+		}
+		return models.BatchResult{Status: models.BatchDuplicate}
+	}
+	bal, ok := balances[e.Wallet()]
+	if !ok {
+		return models.BatchResult{Status: models.BatchInvalid, Err: apperrors.NewBadRequest(errWalletNotFound)}
+	}
+	if bal+e.Amount < 0 {
+		return models.BatchResult{Status: models.BatchInsufficientBalance, Err: errNegativeBalance}
+	}
+	if !(bal + e.Amount).InRange() {
+		return models.BatchResult{Status: models.BatchInvalid, Err: apperrors.NewBadRequest(errBalanceTooLarge)}
+	}
+	balances[e.Wallet()] = bal + e.Amount
+	created[e.TransactionID] = e
+	return models.BatchResult{Status: models.BatchCreated}
+}
+
+// batchWallets returns distinct wallets of the events ordered by account and currency,
+// the order balance rows are locked in
+func batchWallets(events []models.Event) []models.Wallet {
+	seen := make(map[models.Wallet]bool)
+	var wallets []models.Wallet
+	for _, e := range events {
+		if !seen[e.Wallet()] {
+			seen[e.Wallet()] = true
+			wallets = append(wallets, e.Wallet())
+		}
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		if wallets[i].AccountID != wallets[j].AccountID {
+			return wallets[i].AccountID < wallets[j].AccountID
+		}
+		return wallets[i].Currency < wallets[j].Currency
+	})
+	return wallets
+}
+
+func batchTransactionIDs(events []models.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.TransactionID)
+	}
+	return ids
+}
+
+// runIDRef returns reference to the run canceling events, nil for zero runID
+func runIDRef(runID int) *int {
+	if runID == 0 {
+		return nil
+	}
+	return &runID
+}
+
+// cancelEventsByIDs marks events canceled, by the run unless runID is zero
+func cancelEventsByIDs(ctx context.Context, tx *gorm.DB, ids []int, runID int) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	err := tx.Table("events").Where("id IN (?)", ids).Updates(map[string]interface{}{"status": models.StatusCanceled}).Error
+	values := map[string]interface{}{"status": models.StatusCanceled}
+	if runID != 0 {
+		values["cancellation_run_id"] = runID
+	}
+	err := tx.Table("events").Where("id IN (?)", ids).Updates(values).Error
 	return errors.WithStack(err)
 }
 
-func getBalanceWithLock(_ context.Context, tx *gorm.DB) (float64, error) {
+func getBalanceWithLock(ctx context.Context, tx *gorm.DB, w models.Wallet) (models.Money, error) {
+	return getBalance(ctx, tx, w, true)
+}
+
+// needsLock reports whether balance row must be locked explicitly.
+// Serializable transactions detect concurrent balance changes by themselves.
+func needsLock(opts txOptions) bool {
+	return opts.level != TLSerializable
+}
+
+func getBalance(ctx context.Context, tx *gorm.DB, w models.Wallet, forUpdate bool) (models.Money, error) {
 	var res balance
-	err := tx.Raw("SELECT total FROM balance WHERE id = ? FOR UPDATE", 1).
+	query := "SELECT total FROM balance WHERE account_id = ? AND currency = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	err := tx.Raw(query, w.AccountID, w.Currency).
 		Scan(&res).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, apperrors.NewBadRequest(errWalletNotFound)
+	}
 	if err != nil {
 		return 0, errors.Wrap(err, "Can't get balance")
 	}
 	return res.Total, nil
 }
 
-func setBalance(_ context.Context, tx *gorm.DB, total float64) error {
-	err := tx.Table("balance").Where("id = ?", 1).
-		Updates(map[string]interface{}{"total": total}).Error
+// getBalances returns balances of the existing wallets, missing ones don't exist.
+// Rows are locked in the order of wallets, which must be sorted to avoid deadlocks.
+func getBalances(ctx context.Context, tx *gorm.DB, wallets []models.Wallet, forUpdate bool) (map[models.Wallet]models.Money, error) {
+	balances := make(map[models.Wallet]models.Money, len(wallets))
+	if len(wallets) == 0 {
+		return balances, nil
+	}
+	conds := make([]string, 0, len(wallets))
+	args := make([]interface{}, 0, len(wallets)*2)
+	for _, w := range wallets {
+		conds = append(conds, "(account_id = ? AND currency = ?)")
+		args = append(args, w.AccountID, w.Currency)
+	}
+	query := "SELECT account_id, currency, total FROM balance WHERE " + strings.Join(conds, " OR ") +
+		" ORDER BY account_id, currency"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var rows []struct {
+		AccountID int
+		Currency  models.Currency
+		Total     models.Money
+	}
+	if err := tx.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "Can't get balances")
+	}
+	for _, r := range rows {
+		balances[models.Wallet{AccountID: r.AccountID, Currency: r.Currency}] = r.Total
+	}
+	return balances, nil
+}
+
+// setBalance updates wallet balance and returns its new version
+func setBalance(ctx context.Context, tx *gorm.DB, w models.Wallet, total models.Money) (models.Balance, error) {
+	var b models.Balance
+	if !total.InRange() {
+		return b, apperrors.NewBadRequest(errBalanceTooLarge)
+	}
+	err := tx.Raw(`
+			UPDATE balance SET total = ?, version = version + 1, updated_at = ?
+			WHERE account_id = ? AND currency = ?
+			RETURNING account_id, currency, total, version, updated_at`,
+		total, time.Now().UTC(), w.AccountID, w.Currency).
+		Scan(&b).Error
 	if err != nil {
-		return errors.Wrap(err, "Can't update balance")
+		return b, errors.Wrap(err, "Can't update balance")
 	}
-	return nil
+	return b, nil
 }
 
 func validateEventAmount(e models.Event) error {
 	if e.State == models.StateLoss && e.Amount > 0 {
-		return errors.New("Invlid amount")
+		return apperrors.NewValidation("request", errInvalidAmount)
 	}
 	if e.State == models.StateWin && e.Amount < 0 {
-		return errors.New("Invlid amount")
+		return apperrors.NewValidation("request", errInvalidAmount)
+	}
+	if !e.Currency.Fits(e.Amount) || !e.Amount.InRange() {
+		return apperrors.NewValidation("request", errInvalidAmount)
 	}
 	return nil
 }
@@ -3,21 +3,18 @@ package storage
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"sync"
 	"testing"
 	"time"
 
 	"database/sql"
 	"log"
 
+	"github.com/djumpen/test-ex-go/migrations"
 	"github.com/djumpen/test-ex-go/models"
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
-	migrate "github.com/rubenv/sql-migrate"
 	"github.com/stretchr/testify/assert"
 
-	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -29,12 +26,14 @@ type TestPsqlConfig struct {
 	Database string
 }
 
+var testPsqlConfig = TestPsqlConfig{
+	Username: "user",
+	Password: "password",
+	Database: "integration_db",
+}
+
 func setupPostgresContainer(ctx context.Context) (testcontainers.Container, *gorm.DB, error) {
-	cfg := TestPsqlConfig{
-		Username: "user",
-		Password: "password",
-		Database: "integration_db",
-	}
+	cfg := testPsqlConfig
 	req := testcontainers.ContainerRequest{
 		Image:        "postgres:9.6",
 		ExposedPorts: []string{"5432/tcp"},
@@ -56,34 +55,42 @@ func setupPostgresContainer(ctx context.Context) (testcontainers.Container, *gor
 
 	port, err := postgresC.MappedPort(ctx, "5432")
 	if err != nil {
-		return nil, nil, err
+		return postgresC, nil, err
 	}
 
-	connStr := fmt.Sprintf("user=%s password=%s dbname=%s port=%s sslmode=disable",
-		cfg.Username,
-		cfg.Password,
-		cfg.Database,
-		port.Port(),
-	)
-	time.Sleep(10 * time.Second) // Wait for postrgres launch
-	gormDB, err := gorm.Open("postgres", connStr)
-	if err != nil {
-		return nil, nil, err
+	connStr := testConnStr(cfg, port.Port())
+	// Wait for postgres launch, it restarts once after initialization
+	var gormDB *gorm.DB
+	for i := 0; ; i++ {
+		gormDB, err = gorm.Open("postgres", connStr)
+		if err == nil {
+			break
+		}
+		if i >= 30 {
+			return postgresC, nil, err
+		}
+		time.Sleep(time.Second)
 	}
 
 	err = applyMigrations(gormDB.DB())
 	if err != nil {
-		return nil, nil, err
+		return postgresC, nil, err
 	}
 
 	return postgresC, gormDB, nil
 }
 
+func testConnStr(cfg TestPsqlConfig, port string) string {
+	return fmt.Sprintf("user=%s password=%s dbname=%s port=%s sslmode=disable",
+		cfg.Username,
+		cfg.Password,
+		cfg.Database,
+		port,
+	)
+}
+
 func applyMigrations(db *sql.DB) error {
-	migrations := &migrate.FileMigrationSource{
-		Dir: "../migrations",
-	}
-	n, err := migrate.Exec(db, "postgres", migrations, migrate.Up)
+	n, err := migrations.Up(db)
 	if err != nil {
 		return err
 	}
@@ -93,9 +100,7 @@ func applyMigrations(db *sql.DB) error {
 	return nil
 }
 
-// Integration test for checking non-negative balance
-func TestEventCreate(t *testing.T) {
-	a := assert.New(t)
+func TestPostgresConformance(t *testing.T) {
 	ctx := context.Background()
 	postgresC, db, err := setupPostgresContainer(ctx)
 	if postgresC != nil {
@@ -106,54 +111,44 @@ func TestEventCreate(t *testing.T) {
 		return
 	}
 
-	eventsStorage := NewEvents(db)
-	var wg sync.WaitGroup
-
-	assumeTotal := 0.
-	var tl sync.Mutex
-
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			amount := float64(rand.Intn(100))
-			var e models.Event
-			if i%5 == 0 { // StateWin
-				e = genTestEvent(amount)
-			} else { // StateLoss
-				amount = amount * -1
-				e = genTestEvent(amount)
-			}
-			err = eventsStorage.Create(ctx, e)
-			if err != nil && errors.Cause(err) != errNegativeBalance {
-				t.Error(err)
-				return
-			}
-			if err == nil {
-				tl.Lock()
-				assumeTotal += e.Amount
-				tl.Unlock()
-			}
-		}(i)
-		t := time.Duration(rand.Int63n(5))
-		time.Sleep(t * time.Millisecond)
-	}
-
-	wg.Wait()
+	accounts := NewAccounts(db)
+	testEventsConformance(t, NewEvents(db), accounts, NewCancellationRuns(db), NewOutbox(db), accounts.CreateAccount)
+	t.Run("Webhooks", func(t *testing.T) {
+		testWebhooksConformance(t, NewWebhooks(db))
+	})
+}
 
-	bal, err := getBalanceWithLock(ctx, db)
+// Every migration must roll back cleanly, so schema can go down and up again
+func TestMigrationsRollBack(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	postgresC, db, err := setupPostgresContainer(ctx)
+	if postgresC != nil {
+		defer postgresC.Terminate(ctx)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// some data for down migrations to carry
+	w, err := createTestWallet(ctx, db)
 	a.NoError(err)
+	a.NoError(NewEvents(db).Create(ctx, genTestEvent(w, models.MoneyFromInt(10))))
+	a.NoError(migrations.Check(db.DB()))
 
-	t.Logf("Total balance: %f", bal)
-
-	a.Equal(assumeTotal, bal)
+	a.NoError(migrations.Redo(db.DB()))
+	n, err := migrations.Down(db.DB(), 0)
+	a.NoError(err)
+	a.True(n > 0)
+	a.Error(migrations.Check(db.DB()))
 
-	if bal < 0 {
-		t.Error("Negative balance")
-	}
+	_, err = migrations.Up(db.DB())
+	a.NoError(err)
+	a.NoError(migrations.Check(db.DB()))
 }
 
-func TestCancelLastOddEvents(t *testing.T) {
+// Stuck balance lock must not keep request waiting after its deadline
+func TestCreateAbortedOnDeadline(t *testing.T) {
 	a := assert.New(t)
 	ctx := context.Background()
 	postgresC, db, err := setupPostgresContainer(ctx)
@@ -165,37 +160,34 @@ func TestCancelLastOddEvents(t *testing.T) {
 		return
 	}
 
-	eventsStorage := NewEvents(db)
-
-	assumeBalance := 520.
-
-	for i := 0; i < 40; i++ {
-		e := genTestEvent(float64(i) + 1)
-		err := eventsStorage.Create(ctx, e)
-		a.NoError(err)
+	w, err := createTestWallet(ctx, db)
+	if err != nil {
+		t.Error(err)
+		return
 	}
 
-	err = eventsStorage.CancelLastOddEvents(ctx, 10)
+	blocker := db.Begin()
+	_, err = getBalanceWithLock(ctx, blocker, w)
 	a.NoError(err)
+	defer blocker.Rollback()
 
-	bal, err := getBalanceWithLock(ctx, db)
-	a.NoError(err)
+	eventsStorage := NewEvents(db)
+	eventsStorage.txCreate = defaultTx
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = eventsStorage.Create(deadlineCtx, genTestEvent(w, models.MoneyFromInt(10)))
+	a.Equal(context.DeadlineExceeded, errors.Cause(err))
+	a.True(time.Since(started) < 5*time.Second)
 
-	a.Equal(assumeBalance, bal)
+	blocker.Rollback()
+	bal, err := getBalanceWithLock(ctx, db, w)
+	a.NoError(err)
+	a.Equal(models.Money(0), bal)
 }
 
-func genTestEvent(amount float64) models.Event {
-	u := uuid.New()
-	var state models.EventState
-	if amount > 0 {
-		state = models.StateWin
-	} else {
-		state = models.StateLoss
-	}
-	return models.Event{
-		State:         state,
-		Amount:        amount,
-		Status:        models.StatusProcessed,
-		TransactionID: u.String(),
-	}
+func createTestWallet(ctx context.Context, db *gorm.DB) (models.Wallet, error) {
+	accID, err := NewAccounts(db).CreateAccount(ctx, "EUR")
+	return models.Wallet{AccountID: accID, Currency: "EUR"}, err
 }
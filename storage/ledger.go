@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/models"
+)
+
+type ledgerBook string
+type ledgerSide string
+
+const (
+	// bookWallet entries belong to the player wallet
+	bookWallet ledgerBook = "WALLET"
+	// bookHouse entries are the counterparty of wallet ones
+	bookHouse ledgerBook = "HOUSE"
+
+	sideDebit  ledgerSide = "DEBIT"
+	sideCredit ledgerSide = "CREDIT"
+)
+
+// ledgerEntry is a single posting of double-entry journal.
+// Wallet balance is the sum of its credits minus the sum of its debits.
+type ledgerEntry struct {
+	ID        int64
+	JournalID string
+	EventID   int
+	AccountID int
+	Currency  models.Currency
+	Book      ledgerBook
+	Side      ledgerSide
+	Amount    models.Money
+}
+
+func (ledgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// eventJournal returns balanced postings moving event amount between house and wallet,
+// or reversing that movement
+func eventJournal(e models.Event, reverse bool) []ledgerEntry {
+	amount := e.Amount
+	if reverse {
+		amount = -amount
+	}
+	if amount == 0 {
+		return nil
+	}
+	walletSide, houseSide := sideCredit, sideDebit
+	if amount < 0 {
+		walletSide, houseSide = sideDebit, sideCredit
+		amount = -amount
+	}
+	journalID := uuid.New().String()
+	entry := func(book ledgerBook, side ledgerSide) ledgerEntry {
+		return ledgerEntry{
+			JournalID: journalID,
+			EventID:   e.ID,
+			AccountID: e.AccountID,
+			Currency:  e.Currency,
+			Book:      book,
+			Side:      side,
+			Amount:    amount,
+		}
+	}
+	return []ledgerEntry{
+		entry(bookWallet, walletSide),
+		entry(bookHouse, houseSide),
+	}
+}
+
+// postEvent records journal of the newly created event
+func postEvent(ctx context.Context, tx *gorm.DB, e models.Event) error {
+	return postJournal(tx, eventJournal(e, false))
+}
+
+// postCancellation records journal reversing the canceled event
+func postCancellation(ctx context.Context, tx *gorm.DB, e models.Event) error {
+	return postJournal(tx, eventJournal(e, true))
+}
+
+func postJournal(tx *gorm.DB, entries []ledgerEntry) error {
+	for _, entry := range entries {
+		if err := tx.Create(&entry).Error; err != nil {
+			return errors.Wrap(err, "Can't post ledger entry")
+		}
+	}
+	return nil
+}
+
+// getLedgerBalance calculates wallet balance from the ledger
+func getLedgerBalance(ctx context.Context, tx *gorm.DB, w models.Wallet) (models.Money, error) {
+	var res balance
+	err := tx.Raw(`
+			SELECT coalesce(sum(CASE side WHEN ? THEN amount ELSE -amount END), 0) AS total
+			FROM ledger_entries
+			WHERE account_id = ? AND currency = ? AND book = ?`,
+		sideCredit, w.AccountID, w.Currency, bookWallet).
+		Scan(&res).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "Can't get ledger balance")
+	}
+	return res.Total, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/djumpen/test-ex-go/cancellation"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// Cached balance must always match the one calculated from the ledger
+func TestLedgerProjection(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	postgresC, db, err := setupPostgresContainer(ctx)
+	if postgresC != nil {
+		defer postgresC.Terminate(ctx)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	w, err := createTestWallet(ctx, db)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	eventsStorage := NewEvents(db)
+	for i := 0; i < 20; i++ {
+		amount := models.MoneyFromInt(int64(i) + 5)
+		if i%3 == 0 {
+			amount = -amount
+		}
+		_ = eventsStorage.Create(ctx, genTestEvent(w, amount))
+	}
+	_, err = eventsStorage.CancelEvents(ctx, w, cancellation.OddRows(5), cancellation.Options{})
+	a.NoError(err)
+
+	bal, err := getBalanceWithLock(ctx, db, w)
+	a.NoError(err)
+	ledgerBal, err := getLedgerBalance(ctx, db, w)
+	a.NoError(err)
+	a.Equal(bal, ledgerBal)
+
+	var unbalanced []struct{ JournalID string }
+	err = db.Raw(`
+		SELECT journal_id FROM ledger_entries
+		GROUP BY journal_id
+		HAVING sum(CASE side WHEN 'DEBIT' THEN amount ELSE -amount END) <> 0`).
+		Scan(&unbalanced).Error
+	a.NoError(err)
+	a.Empty(unbalanced)
+
+	// ledger is append-only
+	a.Error(db.Exec("UPDATE ledger_entries SET amount = 1").Error)
+	a.Error(db.Exec("DELETE FROM ledger_entries").Error)
+
+	// broken cache is restored from the ledger
+	_, err = setBalance(ctx, db, w, models.MoneyFromInt(1000))
+	a.NoError(err)
+	recomputed, err := eventsStorage.RecomputeBalance(ctx, w)
+	a.NoError(err)
+	a.Equal(ledgerBal, recomputed)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// releaseTimeout limits releasing locks of a connection which may be broken
+const releaseTimeout = 5 * time.Second
+
+// advisoryLock is Postgres session advisory lock held on a dedicated connection.
+// Postgres releases it as soon as the connection is gone,
+// so another process takes it over when the holder dies.
+type advisoryLock struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// NewAdvisoryLock returns lock shared by all processes using the same name
+func NewAdvisoryLock(db *gorm.DB, name string) *advisoryLock {
+	return &advisoryLock{
+		db:  db.DB(),
+		key: advisoryKey(name),
+	}
+}
+
+// advisoryKey turns lock name into Postgres advisory lock key
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// heldQuery reports whether the session holds advisory lock of the bigint key,
+// Postgres keeps its high and low halves as classid and objid
+const heldQuery = `SELECT EXISTS (SELECT 1 FROM pg_locks
+	WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND granted
+		AND classid = (($1::bigint >> 32) & 4294967295)::oid
+		AND objid = ($1::bigint & 4294967295)::oid AND objsubid = 1)`
+
+// TryLock takes the lock unless another process holds it.
+// When the lock is already held it checks the session still holds it, which renews the lease,
+// and gives it up to take the lock again if the session lost it.
+func (l *advisoryLock) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		var held bool
+		err := l.conn.QueryRowContext(ctx, heldQuery, l.key).Scan(&held)
+		if err == nil && held {
+			return true, nil
+		}
+		// the lock can't be trusted anymore, release it if it's still there
+		releaseConn(l.conn)
+		l.conn = nil
+		if ctx.Err() != nil {
+			return false, errors.WithStack(ctx.Err())
+		}
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "Can't get connection for advisory lock")
+	}
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&locked)
+	if err != nil {
+		releaseConn(conn)
+		return false, errors.Wrap(err, "Can't take advisory lock")
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Unlock releases the lock if it's held
+func (l *advisoryLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	conn := l.conn
+	l.conn = nil
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		releaseConn(conn)
+		return errors.Wrap(err, "Can't release advisory lock")
+	}
+	return errors.WithStack(conn.Close())
+}
+
+// releaseConn returns connection to the pool with all its advisory locks released,
+// so none of them outlives the holder. Broken connection is dropped by the pool
+// and Postgres releases its locks with the session.
+func releaseConn(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	_, _ = conn.ExecContext(ctx, "SELECT pg_advisory_unlock_all()")
+	conn.Close()
+}
+
+// localLock is always free, in-memory storage is never shared between processes
+type localLock struct{}
+
+// NewLocalLock returns lock for processes using in-memory storage
+func NewLocalLock() *localLock {
+	return &localLock{}
+}
+
+// TryLock always takes the lock
+func (l *localLock) TryLock(context.Context) (bool, error) {
+	return true, nil
+}
+
+// Unlock does nothing
+func (l *localLock) Unlock(context.Context) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Only one process holds the lock, another one takes over when the holder is gone
+func TestAdvisoryLockFailover(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	postgresC, db, err := setupPostgresContainer(ctx)
+	if postgresC != nil {
+		defer postgresC.Terminate(ctx)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first := NewAdvisoryLock(db, "test")
+	second := NewAdvisoryLock(db, "test")
+
+	locked, err := first.TryLock(ctx)
+	a.NoError(err)
+	a.True(locked)
+	// holder renews the lease
+	locked, err = first.TryLock(ctx)
+	a.NoError(err)
+	a.True(locked)
+
+	locked, err = second.TryLock(ctx)
+	a.NoError(err)
+	a.False(locked)
+
+	a.NoError(first.Unlock(ctx))
+	locked, err = second.TryLock(ctx)
+	a.NoError(err)
+	a.True(locked)
+
+	// holder's session dies
+	err = db.Exec(`
+		SELECT pg_terminate_backend(pid) FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND pid <> pg_backend_pid()`).Error
+	a.NoError(err)
+
+	locked, err = first.TryLock(ctx)
+	a.NoError(err)
+	a.True(locked)
+	locked, err = second.TryLock(ctx)
+	a.NoError(err)
+	a.False(locked)
+
+	// holder's session lost the lock while the connection is alive
+	_, err = first.conn.ExecContext(ctx, "SELECT pg_advisory_unlock_all()")
+	a.NoError(err)
+	locked, err = second.TryLock(ctx)
+	a.NoError(err)
+	a.True(locked)
+	locked, err = first.TryLock(ctx)
+	a.NoError(err)
+	a.False(locked)
+}
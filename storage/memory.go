@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/cancellation"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+	"github.com/djumpen/test-ex-go/webhooks"
+)
+
+// memory keeps accounts and events in process memory.
+// It follows the same rules as Postgres storage and is meant for tests and local development.
+type memory struct {
+	mu            sync.Mutex
+	lastAccountID int
+	balances      map[models.Wallet]*models.Balance
+	events        []models.Event
+	// byTransactionID maps transaction ID to index in events
+	byTransactionID map[string]int
+	runs            []cancellation.Run
+	// outbox keeps messages in the order they were written, published ones included
+	outbox []outbox.Message
+	// cursors are IDs of the last messages published to every consumer
+	cursors map[string]int64
+	// endpoints, deliveries and deadLetters are kept in order of creation
+	endpoints      []webhooks.Endpoint
+	lastEndpointID int
+	deliveries     []webhooks.Delivery
+	lastDeliveryID int64
+	// delivered are IDs of delivered deliveries
+	delivered   map[int64]bool
+	deadLetters []webhooks.DeadLetter
+}
+
+// NewMemory returns in-memory storage of accounts and events
+func NewMemory() *memory {
+	return &memory{
+		balances:        make(map[models.Wallet]*models.Balance),
+		byTransactionID: make(map[string]int),
+		cursors:         make(map[string]int64),
+		delivered:       make(map[int64]bool),
+	}
+}
+
+// CreateAccount opens new account with zero balance in every given currency
+func (s *memory) CreateAccount(_ context.Context, currencies ...models.Currency) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAccountID++
+	for _, c := range currencies {
+		w := models.Wallet{AccountID: s.lastAccountID, Currency: c}
+		s.balances[w] = &models.Balance{Wallet: w, UpdatedAt: time.Now().UTC()}
+	}
+	return s.lastAccountID, nil
+}
+
+// AddCurrency opens zero balance of the account in given currency
+func (s *memory) AddCurrency(_ context.Context, w models.Wallet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.AccountID < 1 || w.AccountID > s.lastAccountID {
+		return errors.WithStack(apperrors.NewNotFound(errAccountNotFound))
+	}
+	if _, ok := s.balances[w]; ok {
+		return errors.WithStack(apperrors.NewConflict(errCurrencyHeld, nil))
+	}
+	s.balances[w] = &models.Balance{Wallet: w, UpdatedAt: time.Now().UTC()}
+	return nil
+}
+
+// Create is for adding new event.
+// Repeated event with the same transaction ID is a no-op, unless its data differs.
+func (s *memory) Create(_ context.Context, e models.Event) error {
+	if err := validateEventAmount(e); err != nil {
+		return errors.WithStack(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bal, ok := s.balances[e.Wallet()]
+	if !ok {
+		return errors.WithStack(apperrors.NewBadRequest(errWalletNotFound))
+	}
+	if i, ok := s.byTransactionID[e.TransactionID]; ok {
+		return checkDuplicate(s.events[i], e)
+	}
+	totalBal := bal.Total + e.Amount
+	if totalBal < 0 {
+		return errors.WithStack(errNegativeBalance)
+	}
+	if !totalBal.InRange() {
+		return errors.WithStack(apperrors.NewBadRequest(errBalanceTooLarge))
+	}
+	e.ID = len(s.events) + 1
+	e.CreatedAt = time.Now().UTC()
+	e.OccurredAt = occurredAt(e)
+	s.byTransactionID[e.TransactionID] = len(s.events)
+	s.events = append(s.events, e)
+	bal.Total, bal.Version, bal.UpdatedAt = totalBal, bal.Version+1, e.CreatedAt
+	s.writeOutbox(outbox.EventCreated(e), outbox.BalanceChanged(*bal))
+	return nil
+}
+
+// CreateBatch adds events with one balance version per wallet.
+// Atomic batch stores nothing unless every event is created or duplicate,
+// otherwise failed events don't affect the others.
+func (s *memory) CreateBatch(_ context.Context, events []models.Event, atomic bool) ([]models.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wallets := batchWallets(events)
+	balances := make(map[models.Wallet]models.Money, len(wallets))
+	for _, w := range wallets {
+		if bal, ok := s.balances[w]; ok {
+			balances[w] = bal.Total
+		}
+	}
+	stored := make(map[string]models.Event)
+	for _, e := range events {
+		if i, ok := s.byTransactionID[e.TransactionID]; ok {
+			stored[e.TransactionID] = s.events[i]
+		}
+	}
+	results := planBatch(events, stored, balances)
+	if atomic && models.BatchFailed(results) {
+		models.AbortBatch(results)
+		return results, nil
+	}
+	var messages []outbox.Message
+	changed := make(map[models.Wallet]bool)
+	now := time.Now().UTC()
+	for i, e := range events {
+		if results[i].Status != models.BatchCreated {
+			continue
+		}
+		e.ID = len(s.events) + 1
+		e.CreatedAt = now
+		e.OccurredAt = occurredAt(e)
+		s.byTransactionID[e.TransactionID] = len(s.events)
+		s.events = append(s.events, e)
+		messages = append(messages, outbox.EventCreated(e))
+		changed[e.Wallet()] = true
+	}
+	for _, w := range wallets {
+		if !changed[w] {
+			continue
+		}
+		bal := s.balances[w]
+		bal.Total, bal.Version, bal.UpdatedAt = balances[w], bal.Version+1, now
+		messages = append(messages, outbox.BalanceChanged(*bal))
+	}
+	s.writeOutbox(messages...)
+	return results, nil
+}
+
+// CancelEvents cancels events of the wallet chosen by the strategy and recalculates its balance.
+// Dry run only computes what would be canceled,
+// partial run skips events which would make balance negative.
+func (s *memory) CancelEvents(_ context.Context, w models.Wallet, strategy cancellation.Strategy,
+	opts cancellation.Options) (cancellation.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := cancellation.Result{Wallet: w, DryRun: opts.DryRun}
+	bal, ok := s.balances[w]
+	if !ok {
+		return res, errors.WithStack(apperrors.NewBadRequest(errWalletNotFound))
+	}
+	candidates, total := s.candidateEvents(strategy.Candidates(w, time.Now().UTC()))
+	canceled := strategy.Select(candidates, total)
+	if opts.Partial {
+		var skipped []models.Event
+		canceled, skipped = cancellation.Fit(canceled, bal.Total)
+		for _, e := range skipped {
+			res.SkippedIDs = append(res.SkippedIDs, e.ID)
+		}
+	}
+	var canBal models.Money
+	res.EventIDs = make([]int, 0, len(canceled))
+	for _, e := range canceled {
+		canBal -= e.Amount
+		res.EventIDs = append(res.EventIDs, e.ID)
+	}
+	res.BalanceBefore, res.BalanceAfter = bal.Total, bal.Total+canBal
+	if res.BalanceAfter < 0 {
+		return res, errors.Wrapf(errNegativeBalance, "Canceling events error for account %d in %s", w.AccountID, w.Currency)
+	}
+	// nothing changes, balance keeps its version
+	if opts.DryRun || len(res.EventIDs) == 0 {
+		return res, nil
+	}
+	now := time.Now().UTC()
+	for _, id := range res.EventIDs {
+		s.events[id-1].Status = models.StatusCanceled
+		s.events[id-1].CancellationRunID = runIDRef(opts.RunID)
+		s.writeOutbox(outbox.EventCanceled(s.events[id-1], now))
+	}
+	bal.Total, bal.Version, bal.UpdatedAt = res.BalanceAfter, bal.Version+1, now
+	s.writeOutbox(outbox.BalanceChanged(*bal))
+	return res, nil
+}
+
+// candidateEvents lists events matching the filter in ingestion order or by occurrence time,
+// newest first unless the filter says otherwise, and counts all of them
+func (s *memory) candidateEvents(f models.EventFilter) ([]models.Event, int) {
+	var matched []models.Event
+	for i := range s.events {
+		e := s.events[len(s.events)-1-i]
+		if f.OldestFirst {
+			e = s.events[i]
+		}
+		if f.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if f.Time == models.OccurrenceTime {
+		// stable sort keeps ingestion order of events occurred at the same time
+		sort.SliceStable(matched, func(i, j int) bool {
+			ei, ej := matched[i].OccurredAt, matched[j].OccurredAt
+			if f.OldestFirst {
+				return ei.Before(ej)
+			}
+			return ei.After(ej)
+		})
+	}
+	total := len(matched)
+	if f.Limit > 0 && f.Limit < total {
+		matched = matched[:f.Limit]
+	}
+	return matched, total
+}
+
+// Get returns event by its transaction ID
+func (s *memory) Get(_ context.Context, transactionID string) (models.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.byTransactionID[transactionID]
+	if !ok {
+		return models.Event{}, errors.WithStack(apperrors.NewNotFound(errEventNotFound))
+	}
+	return s.events[i], nil
+}
+
+// List returns page of events matching the filter and total number of matching events
+func (s *memory) List(_ context.Context, f models.EventFilter) ([]models.Event, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := []models.Event{}
+	for _, e := range s.events {
+		if f.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		ei, ej := matched[i], matched[j]
+		if f.OldestFirst {
+			ei, ej = ej, ei
+		}
+		if ti, tj := ei.Time(f.Time), ej.Time(f.Time); !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return ei.ID > ej.ID
+	})
+	total := len(matched)
+	if f.Offset >= total {
+		return []models.Event{}, total, nil
+	}
+	matched = matched[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(matched) {
+		matched = matched[:f.Limit]
+	}
+	return matched, total, nil
+}
+
+// Balance returns current wallet balance
+func (s *memory) Balance(_ context.Context, w models.Wallet) (models.Money, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bal, ok := s.balances[w]
+	if !ok {
+		return 0, errors.WithStack(apperrors.NewBadRequest(errWalletNotFound))
+	}
+	return bal.Total, nil
+}
+
+// AccountBalances returns balances of the account in all its currencies
+func (s *memory) AccountBalances(_ context.Context, accountID int) ([]models.Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var balances []models.Balance
+	for w, bal := range s.balances {
+		if w.AccountID == accountID {
+			balances = append(balances, *bal)
+		}
+	}
+	if len(balances) == 0 {
+		return nil, errors.WithStack(apperrors.NewNotFound(errAccountNotFound))
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency < balances[j].Currency
+	})
+	return balances, nil
+}
+
+// Wallets returns all existing account balances
+func (s *memory) Wallets(_ context.Context) ([]models.Wallet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wallets := make([]models.Wallet, 0, len(s.balances))
+	for w := range s.balances {
+		wallets = append(wallets, w)
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		if wallets[i].AccountID != wallets[j].AccountID {
+			return wallets[i].AccountID < wallets[j].AccountID
+		}
+		return wallets[i].Currency < wallets[j].Currency
+	})
+	return wallets, nil
+}
+
+// StartRun records beginning of the run and returns its ID
+func (s *memory) StartRun(_ context.Context, run cancellation.Run) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.ID = len(s.runs) + 1
+	run.Results = nil
+	s.runs = append(s.runs, run)
+	return run.ID, nil
+}
+
+// FinishRun records outcome of the run in every wallet
+func (s *memory) FinishRun(_ context.Context, run cancellation.Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if run.ID < 1 || run.ID > len(s.runs) {
+		return errors.Errorf("Cancellation run %d not found", run.ID)
+	}
+	stored := &s.runs[run.ID-1]
+	stored.FinishedAt, stored.Err = run.FinishedAt, run.Err
+	stored.Results = append([]cancellation.Result(nil), run.Results...)
+	return nil
+}
+
+// ListRuns returns page of runs, newest first, and total number of runs
+func (s *memory) ListRuns(_ context.Context, limit, offset int) ([]cancellation.Run, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := len(s.runs)
+	runs := []cancellation.Run{}
+	for i := total - 1 - offset; i >= 0 && (limit <= 0 || len(runs) < limit); i-- {
+		runs = append(runs, s.runs[i])
+	}
+	return runs, total, nil
+}
+
+// Pending returns the oldest messages the consumer hasn't published yet in order, all of them if limit is zero.
+// Nothing outlives the process, so a new consumer starts with the first message.
+func (s *memory) Pending(_ context.Context, consumer string, limit int) ([]outbox.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.cursors[consumer]
+	// message ID is its position in outbox from 1
+	messages := []outbox.Message{}
+	for _, m := range s.outbox[last:] {
+		if limit > 0 && len(messages) >= limit {
+			break
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// MarkPublished moves the consumer past the messages, they are published in order,
+// so the earlier ones aren't published to it again either
+func (s *memory) MarkPublished(_ context.Context, consumer string, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if id > s.cursors[consumer] {
+			s.cursors[consumer] = id
+		}
+	}
+	return nil
+}
+
+func (s *memory) writeOutbox(messages ...outbox.Message) {
+	for _, m := range messages {
+		m.ID = int64(len(s.outbox) + 1)
+		s.outbox = append(s.outbox, m)
+	}
+}
+
+// CreateEndpoint stores new endpoint and returns it with ID
+func (s *memory) CreateEndpoint(_ context.Context, ep webhooks.Endpoint) (webhooks.Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastEndpointID++
+	ep.ID = s.lastEndpointID
+	if ep.CreatedAt.IsZero() {
+		ep.CreatedAt = time.Now().UTC()
+	}
+	s.endpoints = append(s.endpoints, ep)
+	return ep, nil
+}
+
+// Endpoints returns all endpoints in order of creation
+func (s *memory) Endpoints(_ context.Context) ([]webhooks.Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]webhooks.Endpoint{}, s.endpoints...), nil
+}
+
+// DeleteEndpoint removes endpoint with its deliveries and dead letters
+func (s *memory) DeleteEndpoint(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, ep := range s.endpoints {
+		if ep.ID != id {
+			continue
+		}
+		s.endpoints = append(s.endpoints[:i], s.endpoints[i+1:]...)
+		deliveries := s.deliveries[:0]
+		for _, d := range s.deliveries {
+			if d.EndpointID != id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		s.deliveries = deliveries
+		letters := s.deadLetters[:0]
+		for _, l := range s.deadLetters {
+			if l.EndpointID != id {
+				letters = append(letters, l)
+			}
+		}
+		s.deadLetters = letters
+		return nil
+	}
+	return errors.WithStack(apperrors.NewNotFound(errEndpointNotFound))
+}
+
+// EnqueueDeliveries skips deliveries already queued for the endpoint with the same key
+func (s *memory) EnqueueDeliveries(_ context.Context, deliveries []webhooks.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range deliveries {
+		if s.findDelivery(d.EndpointID, d.Key) < 0 {
+			s.lastDeliveryID++
+			d.ID, d.Attempts, d.LastError = s.lastDeliveryID, 0, ""
+			s.deliveries = append(s.deliveries, d)
+		}
+	}
+	return nil
+}
+
+// DueDeliveries returns undelivered deliveries to enabled endpoints due at the time, the earliest first,
+// limit applies to every endpoint
+func (s *memory) DueDeliveries(_ context.Context, now time.Time, limit int) ([]webhooks.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disabled := make(map[int]bool)
+	for _, ep := range s.endpoints {
+		disabled[ep.ID] = ep.DisabledAt != nil
+	}
+	due := []webhooks.Delivery{}
+	for _, d := range s.deliveries {
+		if !s.delivered[d.ID] && !d.NextAttemptAt.After(now) && !disabled[d.EndpointID] {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if limit <= 0 {
+		return due, nil
+	}
+	perEndpoint := make(map[int]int)
+	limited := due[:0]
+	for _, d := range due {
+		if perEndpoint[d.EndpointID] < limit {
+			perEndpoint[d.EndpointID]++
+			limited = append(limited, d)
+		}
+	}
+	return limited, nil
+}
+
+// MarkDelivered keeps delivery from being sent again
+func (s *memory) MarkDelivered(_ context.Context, id int64, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[id] = true
+	return nil
+}
+
+// RetryDelivery counts failed attempt and schedules the next one
+func (s *memory) RetryDelivery(_ context.Context, id int64, next time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if d := &s.deliveries[i]; d.ID == id {
+			d.Attempts, d.NextAttemptAt, d.LastError = d.Attempts+1, next, lastErr
+		}
+	}
+	return nil
+}
+
+// BuryDelivery counts failed attempt and moves delivery to dead letters
+func (s *memory) BuryDelivery(_ context.Context, id int64, at time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, d := range s.deliveries {
+		if d.ID != id {
+			continue
+		}
+		s.deliveries = append(s.deliveries[:i], s.deliveries[i+1:]...)
+		d.Attempts, d.NextAttemptAt, d.LastError = d.Attempts+1, time.Time{}, lastErr
+		s.deadLetters = append(s.deadLetters, webhooks.DeadLetter{Delivery: d, FailedAt: at})
+		break
+	}
+	return nil
+}
+
+// EndpointFailed counts failed attempt to the endpoint and disables it after disableAfter ones in a row,
+// it reports whether the endpoint got disabled
+func (s *memory) EndpointFailed(_ context.Context, id int, at time.Time, disableAfter int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep := s.findEndpoint(id)
+	if ep == nil {
+		return false, nil
+	}
+	ep.Failures++
+	if disableAfter <= 0 || ep.Failures < disableAfter || ep.DisabledAt != nil {
+		return false, nil
+	}
+	ep.DisabledAt = &at
+	return true, nil
+}
+
+// EndpointSucceeded resets failures of the endpoint
+func (s *memory) EndpointSucceeded(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ep := s.findEndpoint(id); ep != nil {
+		ep.Failures = 0
+	}
+	return nil
+}
+
+// EnableEndpoint resets failures of the endpoint and lets its deliveries go again
+func (s *memory) EnableEndpoint(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep := s.findEndpoint(id)
+	if ep == nil {
+		return errors.WithStack(apperrors.NewNotFound(errEndpointNotFound))
+	}
+	ep.Failures, ep.DisabledAt = 0, nil
+	return nil
+}
+
+// DeadLetters returns page of dead letters of the endpoint, or of all endpoints if endpointID is zero,
+// the latest failed first, and their total number
+func (s *memory) DeadLetters(_ context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := []webhooks.DeadLetter{}
+	for i := len(s.deadLetters) - 1; i >= 0; i-- {
+		if l := s.deadLetters[i]; endpointID <= 0 || l.EndpointID == endpointID {
+			matched = append(matched, l)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].FailedAt.After(matched[j].FailedAt)
+	})
+	total := len(matched)
+	if offset >= total {
+		return []webhooks.DeadLetter{}, total, nil
+	}
+	matched = matched[offset:]
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+	return matched, total, nil
+}
+
+// ReplayDeadLetters moves dead letters back to deliveries due at the time with attempts reset.
+// Empty ids replays all dead letters of the endpoint, or of all endpoints if endpointID is zero.
+func (s *memory) ReplayDeadLetters(_ context.Context, endpointID int, ids []int64, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	selected := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+	replayed := 0
+	letters := s.deadLetters[:0]
+	for _, l := range s.deadLetters {
+		if (endpointID > 0 && l.EndpointID != endpointID) || (len(ids) > 0 && !selected[l.ID]) {
+			letters = append(letters, l)
+			continue
+		}
+		// the same message queued again meanwhile is delivered once
+		if s.findDelivery(l.EndpointID, l.Key) >= 0 {
+			continue
+		}
+		d := l.Delivery
+		d.Attempts, d.NextAttemptAt = 0, at
+		s.deliveries = append(s.deliveries, d)
+		replayed++
+	}
+	s.deadLetters = letters
+	return replayed, nil
+}
+
+// findEndpoint returns the stored endpoint, nil if there is none
+func (s *memory) findEndpoint(id int) *webhooks.Endpoint {
+	for i := range s.endpoints {
+		if s.endpoints[i].ID == id {
+			return &s.endpoints[i]
+		}
+	}
+	return nil
+}
+
+// findDelivery returns index of delivery to the endpoint with the key, -1 if there is none
+func (s *memory) findDelivery(endpointID int, key string) int {
+	for i, d := range s.deliveries {
+		if d.EndpointID == endpointID && d.Key == key {
+			return i
+		}
+	}
+	return -1
+}
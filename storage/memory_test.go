@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	st := NewMemory()
+	testEventsConformance(t, st, st, st, st, st.CreateAccount)
+}
+
+func TestMemoryWebhooksConformance(t *testing.T) {
+	testWebhooksConformance(t, NewMemory())
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/outbox"
+	"github.com/jinzhu/gorm"
+)
+
+// maxNotifyPayload is Postgres limit of NOTIFY payload, in bytes
+const maxNotifyPayload = 8000
+
+// notifier sends outbox messages to every process listening on the channel with Postgres NOTIFY
+type notifier struct {
+	db      *gorm.DB
+	channel string
+}
+
+// NewNotifier returns outbox publisher notifying the channel
+func NewNotifier(db *gorm.DB, channel string) *notifier {
+	return &notifier{
+		db:      db,
+		channel: channel,
+	}
+}
+
+func (n *notifier) Publish(ctx context.Context, m outbox.Message) error {
+	b, err := outbox.Encode(m)
+	if err != nil {
+		return err
+	}
+	if len(b) >= maxNotifyPayload {
+		// metadata limit keeps messages far below it, retrying would stop the outbox for good
+		log.Printf("Message %s is too large to notify, skipping", m.Key)
+		return nil
+	}
+	// notification is sent on commit
+	err = withTransaction(ctx, n.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Exec("SELECT pg_notify(?, ?)", n.channel, string(b)).Error
+	})
+	return errors.Wrapf(err, "Can't notify message %s", m.Key)
+}
+
+// listener receives messages notified on the channel over its own connection
+type listener struct {
+	connStr string
+	channel string
+}
+
+// NewListener returns listener of the channel connecting with connStr
+func NewListener(connStr, channel string) *listener {
+	return &listener{
+		connStr: connStr,
+		channel: channel,
+	}
+}
+
+// listenerPing checks idle connection is alive, so lost one is noticed and restored
+const listenerPing = 90 * time.Second
+
+// Listen passes notified messages to onMessage in order until ctx is done.
+// Lost connection is restored, onGap is called then as messages notified meanwhile are missed.
+func (l *listener) Listen(ctx context.Context, onMessage func(outbox.Message), onGap func()) error {
+	pl := pq.NewListener(l.connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener of %s: %s", l.channel, err)
+		}
+	})
+	defer pl.Close()
+	if err := pl.Listen(l.channel); err != nil {
+		return errors.Wrapf(err, "Can't listen to %s", l.channel)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-pl.Notify:
+			if n == nil {
+				log.Printf("Listener of %s reconnected, messages notified meanwhile are missed", l.channel)
+				onGap()
+				continue
+			}
+			m, err := outbox.Decode([]byte(n.Extra))
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			onMessage(m)
+		case <-time.After(listenerPing):
+			go pl.Ping()
+		}
+	}
+}
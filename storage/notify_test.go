@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// Every listener gets notified messages in order
+func TestNotifyListeners(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	postgresC, db, err := setupPostgresContainer(ctx)
+	if postgresC != nil {
+		defer postgresC.Terminate(ctx)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	port, err := postgresC.MappedPort(ctx, "5432")
+	if !a.NoError(err) {
+		return
+	}
+	connStr := testConnStr(testPsqlConfig, port.Port())
+
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	received := [2]chan outbox.Message{make(chan outbox.Message, 10), make(chan outbox.Message, 10)}
+	for _, c := range received {
+		c := c
+		go NewListener(connStr, "test").Listen(listenCtx, func(m outbox.Message) {
+			c <- m
+		}, func() {})
+	}
+	// listeners connect in background
+	time.Sleep(time.Second)
+
+	n := NewNotifier(db, "test")
+	for i := int64(1); i <= 3; i++ {
+		a.NoError(n.Publish(ctx, outbox.Message{ID: i, Topic: outbox.TopicEventCreated, Payload: []byte(`{"id":1}`)}))
+	}
+	for _, c := range received {
+		for i := int64(1); i <= 3; i++ {
+			select {
+			case m := <-c:
+				a.Equal(i, m.ID)
+				a.JSONEq(`{"id":1}`, string(m.Payload))
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+			}
+		}
+	}
+}
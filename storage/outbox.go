@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/outbox"
+	"github.com/jinzhu/gorm"
+)
+
+type outboxStorage struct {
+	db *gorm.DB
+}
+
+// NewOutbox returns storage of messages written along with changes
+func NewOutbox(db *gorm.DB) *outboxStorage {
+	return &outboxStorage{
+		db: db,
+	}
+}
+
+// outboxMessage is row of outbox table
+type outboxMessage struct {
+	ID int64
+	// Seq is place of the committed message in relay order, consumers know the message by it
+	Seq       *int64
+	Key       string
+	Topic     string
+	Payload   string
+	CreatedAt time.Time
+}
+
+func (outboxMessage) TableName() string {
+	return "outbox"
+}
+
+// Pending returns the oldest messages the consumer hasn't published yet in order, all of them if limit is zero.
+// A new consumer starts with messages written from then on.
+func (s *outboxStorage) Pending(ctx context.Context, consumer string, limit int) ([]outbox.Message, error) {
+	var rows []outboxMessage
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		if err := sequenceOutbox(ctx, tx); err != nil {
+			return err
+		}
+		err := tx.Exec(`
+			INSERT INTO outbox_cursors(consumer, last_id)
+			SELECT ?, coalesce(max(seq), 0) FROM outbox
+			ON CONFLICT (consumer) DO NOTHING`, consumer).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		q := tx.Where("seq > (SELECT last_id FROM outbox_cursors WHERE consumer = ?)", consumer).Order("seq")
+		if limit > 0 {
+			q = q.Limit(limit)
+		}
+		return q.Find(&rows).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Storage error while getting pending messages")
+	}
+	messages := make([]outbox.Message, 0, len(rows))
+	for _, r := range rows {
+		messages = append(messages, outbox.Message{
+			ID:        *r.Seq,
+			Key:       r.Key,
+			Topic:     r.Topic,
+			Payload:   []byte(r.Payload),
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	return messages, nil
+}
+
+// MarkPublished moves the consumer past the messages, they are published in order,
+// so the earlier ones aren't published to it again either
+func (s *outboxStorage) MarkPublished(ctx context.Context, consumer string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE outbox_cursors SET last_id = ? WHERE consumer = ? AND last_id < ?",
+			maxID(ids), consumer, maxID(ids)).Error
+	})
+	return errors.Wrap(err, "Storage error while marking messages published")
+}
+
+func maxID(ids []int64) int64 {
+	var max int64
+	for _, id := range ids {
+		if id > max {
+			max = id
+		}
+	}
+	return max
+}
+
+// outboxSequenceLock serializes relays numbering messages
+var outboxSequenceLock = advisoryKey("outbox:sequence")
+
+// sequenceOutbox numbers messages committed since the last call after the ones numbered before.
+// A message committed later gets a greater number, so consumers moving past a number never skip a message.
+func sequenceOutbox(ctx context.Context, tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", outboxSequenceLock).Error; err != nil {
+		return errors.Wrap(err, "Can't lock outbox sequence")
+	}
+	// the statement sees messages numbered by relays holding the lock before
+	err := tx.Exec(`
+		UPDATE outbox o SET seq = n.seq
+		FROM (
+			SELECT id, (SELECT coalesce(max(seq), 0) FROM outbox) + row_number() OVER (ORDER BY id) AS seq
+			FROM outbox WHERE seq IS NULL
+		) n
+		WHERE o.id = n.id`).Error
+	return errors.Wrap(err, "Can't number outbox messages")
+}
+
+// writeOutbox adds messages in the transaction making the change.
+// They are numbered for consumers once committed, so writers don't wait for each other.
+func writeOutbox(ctx context.Context, tx *gorm.DB, messages ...outbox.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	for _, m := range messages {
+		row := outboxMessage{
+			Key:       m.Key,
+			Topic:     m.Topic,
+			Payload:   string(m.Payload),
+			CreatedAt: m.CreatedAt,
+		}
+		if err := tx.Create(&row).Error; err != nil {
+			return errors.Wrap(err, "Can't write outbox message")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// Writes to different wallets don't wait for each other,
+// and a message committed later must not get ID below the one already visible to the relay
+func TestOutboxCommitOrder(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	postgresC, db, err := setupPostgresContainer(ctx)
+	if postgresC != nil {
+		defer postgresC.Terminate(ctx)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	st := NewOutbox(db)
+	first, err := createTestWallet(ctx, db)
+	a.NoError(err)
+	second, err := createTestWallet(ctx, db)
+	a.NoError(err)
+
+	// the consumer starts at the current end of outbox
+	_, err = st.Pending(ctx, "test", 0)
+	a.NoError(err)
+
+	firstTx := db.Begin()
+	defer firstTx.Rollback()
+	_, err = getBalanceWithLock(ctx, firstTx, first)
+	a.NoError(err)
+	b, err := setBalance(ctx, firstTx, first, models.MoneyFromInt(10))
+	a.NoError(err)
+	a.NoError(writeOutbox(ctx, firstTx, outbox.BalanceChanged(b)))
+
+	writeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	a.NoError(NewEvents(db).Create(writeCtx, genTestEvent(second, models.MoneyFromInt(5))),
+		"write to another wallet waits for the open transaction")
+
+	pending, err := st.Pending(ctx, "test", 0)
+	a.NoError(err)
+	a.Len(pending, 2)
+
+	a.NoError(firstTx.Commit().Error)
+	pending, err = st.Pending(ctx, "test", 0)
+	a.NoError(err)
+	if a.Len(pending, 3) {
+		a.Equal(outbox.BalanceChanged(b).Key, pending[2].Key)
+		a.True(pending[1].ID < pending[2].ID)
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"log"
+	"math/rand"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -14,12 +16,68 @@ import (
 type TLevel int
 
 const (
-	TLRepeatbleRead TLevel = iota + 1
+	// TLDefault keeps database default level (read committed)
+	TLDefault TLevel = iota
+	TLRepeatbleRead
 	TLSerializable
 )
 
-func withTransaction(db *gorm.DB, f func(tx *gorm.DB) error) error {
-	tx := db.Begin()
+// txOptions defines how storage operation runs its transaction
+type txOptions struct {
+	level TLevel
+	retry retryPolicy
+}
+
+// retryPolicy repeats failed attempts with exponential backoff and jitter
+type retryPolicy struct {
+	check     errorChecker
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+var (
+	defaultTx = txOptions{
+		level: TLDefault,
+		retry: noRetry,
+	}
+	serializableTx = txOptions{
+		level: TLSerializable,
+		retry: retryPolicy{
+			check:     onSerializationFailures,
+			attempts:  10,
+			baseDelay: 5 * time.Millisecond,
+			maxDelay:  500 * time.Millisecond,
+		},
+	}
+	noRetry = retryPolicy{attempts: 1}
+)
+
+// withTransaction runs f in a transaction with given level,
+// repeating the whole transaction according to retry policy.
+// Transaction is rolled back once the context is done.
+func withTransaction(ctx context.Context, db *gorm.DB, opts txOptions, f func(tx *gorm.DB) error) error {
+	err := retryWithStrategy(ctx, opts.retry, func() error {
+		return runTransaction(ctx, db, opts.level, f)
+	})
+	if err != nil && ctx.Err() != nil {
+		// report the reason instead of whatever the aborted query returned
+		return errors.Wrapf(ctx.Err(), "transaction aborted: %v", err)
+	}
+	return err
+}
+
+func runTransaction(ctx context.Context, db *gorm.DB, lvl TLevel, f func(tx *gorm.DB) error) error {
+	tx, err := beginTx(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	if lvl != TLDefault {
+		if err := setTransactionLevel(tx, lvl); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "failed to set transaction level")
+		}
+	}
 	if err := f(tx); err != nil {
 		tx.Rollback()
 		return err
@@ -43,6 +101,20 @@ func setTransactionLevel(tx *gorm.DB, lvl TLevel) error {
 
 type errorChecker func(err error) bool
 
+func isUniqueViolation(err error, constraint string) bool {
+	if err, ok := errors.Cause(err).(*pq.Error); ok {
+		return err.Code == "23505" && err.Constraint == constraint
+	}
+	return false
+}
+
+func isForeignKeyViolation(err error, constraint string) bool {
+	if err, ok := errors.Cause(err).(*pq.Error); ok {
+		return err.Code == "23503" && err.Constraint == constraint
+	}
+	return false
+}
+
 func onSerializationFailures(err error) bool {
 	err = errors.Cause(err)
 	if err, ok := err.(*pq.Error); ok {
@@ -54,20 +126,44 @@ func onSerializationFailures(err error) bool {
 	return false
 }
 
-func retryWithStrategy(check errorChecker, attempts int, sleep time.Duration, f func() error) (err error) {
+func retryWithStrategy(ctx context.Context, p retryPolicy, f func() error) (err error) {
 	for i := 0; ; i++ {
 		err = f()
 		if err == nil {
 			return
 		}
-		if !check(err) {
+		if p.check == nil || !p.check(err) {
 			return err
 		}
-		if i >= (attempts - 1) {
+		if i >= (p.attempts - 1) {
 			break
 		}
-		time.Sleep(sleep)
 		log.Printf("retrying after error[%d]: %v", i, err)
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "retry aborted: %v", ctx.Err())
+		case <-time.After(p.backoff(i)):
+		}
+	}
+	return errors.Wrapf(err, "after %d attempts", p.attempts)
+}
+
+// orOn returns the policy also repeating attempts failed with errors check accepts
+func (p retryPolicy) orOn(check errorChecker) retryPolicy {
+	prev := p.check
+	p.check = func(err error) bool {
+		return check(err) || prev != nil && prev(err)
+	}
+	return p
+}
+
+// backoff returns delay before next attempt: exponentially growing,
+// capped by maxDelay, with random half of it to spread concurrent retries
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.maxDelay
+	if attempt < 32 && p.baseDelay<<uint(attempt) < p.maxDelay {
+		d = p.baseDelay << uint(attempt)
 	}
-	return errors.Wrapf(err, "after %d attempts", attempts)
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
 }
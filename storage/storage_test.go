@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWithStrategy(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	policy := retryPolicy{
+		check:     onSerializationFailures,
+		attempts:  3,
+		baseDelay: time.Millisecond,
+		maxDelay:  2 * time.Millisecond,
+	}
+	serializationErr := errors.WithStack(&pq.Error{Code: "40001"})
+
+	calls := 0
+	err := retryWithStrategy(ctx, policy, func() error {
+		calls++
+		if calls < 3 {
+			return serializationErr
+		}
+		return nil
+	})
+	a.NoError(err)
+	a.Equal(3, calls)
+
+	calls = 0
+	err = retryWithStrategy(ctx, policy, func() error {
+		calls++
+		return serializationErr
+	})
+	a.Error(err)
+	a.Equal(3, calls)
+
+	// other errors are not retried
+	calls = 0
+	err = retryWithStrategy(ctx, policy, func() error {
+		calls++
+		return errNegativeBalance
+	})
+	a.Equal(errNegativeBalance, err)
+	a.Equal(1, calls)
+
+	// cancelled context stops retries
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err = retryWithStrategy(cancelled, policy, func() error {
+		calls++
+		return serializationErr
+	})
+	a.Error(err)
+	a.Equal(1, calls)
+}
+
+func TestRetryOrOn(t *testing.T) {
+	a := assert.New(t)
+	policy := retryPolicy{check: onSerializationFailures, attempts: 3}.orOn(onDuplicateTransactionID)
+
+	a.True(policy.check(&pq.Error{Code: "40001"}))
+	a.True(policy.check(errors.WithStack(&pq.Error{Code: "23505", Constraint: transactionIDIndex})))
+	a.False(policy.check(&pq.Error{Code: "23505", Constraint: "other_uindex"}))
+
+	// repeated violation stops after the attempts
+	calls := 0
+	err := retryWithStrategy(context.Background(), policy, func() error {
+		calls++
+		return &pq.Error{Code: "23505", Constraint: transactionIDIndex}
+	})
+	a.Error(err)
+	a.Equal(3, calls)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	a := assert.New(t)
+	p := retryPolicy{baseDelay: 10 * time.Millisecond, maxDelay: 100 * time.Millisecond}
+
+	for i := 0; i < 50; i++ {
+		d := p.backoff(0)
+		a.True(d >= 5*time.Millisecond && d <= 10*time.Millisecond, d)
+		d = p.backoff(2)
+		a.True(d >= 20*time.Millisecond && d <= 40*time.Millisecond, d)
+		d = p.backoff(40)
+		a.True(d >= 50*time.Millisecond && d <= 100*time.Millisecond, d)
+	}
+}
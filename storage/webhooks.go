@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/apperrors"
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/webhooks"
+	"github.com/jinzhu/gorm"
+)
+
+type webhooksStorage struct {
+	db *gorm.DB
+}
+
+// NewWebhooks returns storage of webhook endpoints and their deliveries
+func NewWebhooks(db *gorm.DB) *webhooksStorage {
+	return &webhooksStorage{
+		db: db,
+	}
+}
+
+type webhookEndpoint struct {
+	ID          int
+	URL         string `gorm:"column:url"`
+	Secret      string
+	States      string
+	Statuses    string
+	SourceTypes string
+	CreatedAt   time.Time
+	Failures    int
+	DisabledAt  *time.Time
+}
+
+func (webhookEndpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+type webhookDelivery struct {
+	ID            int64
+	EndpointID    int
+	Key           string
+	Topic         string
+	Payload       string
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	DeliveredAt   *time.Time
+}
+
+func (webhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+type webhookDeadLetter struct {
+	ID         int64
+	EndpointID int
+	Key        string
+	Topic      string
+	Payload    string
+	CreatedAt  time.Time
+	Attempts   int
+	LastError  string
+	FailedAt   time.Time
+}
+
+func (webhookDeadLetter) TableName() string {
+	return "webhook_dead_letters"
+}
+
+// CreateEndpoint stores new endpoint and returns it with ID
+func (s *webhooksStorage) CreateEndpoint(ctx context.Context, ep webhooks.Endpoint) (webhooks.Endpoint, error) {
+	row, err := newWebhookEndpoint(ep)
+	if err != nil {
+		return ep, errors.WithStack(err)
+	}
+	err = withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Create(&row).Error
+	})
+	if err != nil {
+		return ep, errors.Wrap(err, "Storage error while creating webhook endpoint")
+	}
+	ep.ID, ep.CreatedAt = row.ID, row.CreatedAt
+	return ep, nil
+}
+
+// Endpoints returns all endpoints in order of creation
+func (s *webhooksStorage) Endpoints(ctx context.Context) ([]webhooks.Endpoint, error) {
+	var rows []webhookEndpoint
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Order("id").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Storage error while listing webhook endpoints")
+	}
+	endpoints := make([]webhooks.Endpoint, 0, len(rows))
+	for _, row := range rows {
+		ep, err := row.toEndpoint()
+		if err != nil {
+			return nil, errors.Wrap(err, "Storage error while listing webhook endpoints")
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpoint removes endpoint with its deliveries and dead letters
+func (s *webhooksStorage) DeleteEndpoint(ctx context.Context, id int) error {
+	var deleted int64
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		res := tx.Delete(&webhookEndpoint{}, "id = ?", id)
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return errors.Wrap(err, "Storage error while deleting webhook endpoint")
+	}
+	if deleted == 0 {
+		return errors.WithStack(apperrors.NewNotFound(errEndpointNotFound))
+	}
+	return nil
+}
+
+// EnqueueDeliveries skips deliveries already queued for the endpoint with the same key
+func (s *webhooksStorage) EnqueueDeliveries(ctx context.Context, deliveries []webhooks.Delivery) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		for _, d := range deliveries {
+			err := tx.Exec(`INSERT INTO webhook_deliveries (endpoint_id, key, topic, payload, created_at, next_attempt_at)
+				VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (endpoint_id, key) DO NOTHING`,
+				d.EndpointID, d.Key, d.Topic, string(d.Payload), d.CreatedAt.UTC(), d.NextAttemptAt.UTC()).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "Storage error while queueing webhook deliveries")
+}
+
+// DueDeliveries returns undelivered deliveries to enabled endpoints due at the time, the earliest first,
+// limit applies to every endpoint
+func (s *webhooksStorage) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhooks.Delivery, error) {
+	const due = `delivered_at IS NULL AND next_attempt_at <= ?
+		AND endpoint_id NOT IN (SELECT id FROM webhook_endpoints WHERE disabled_at IS NOT NULL)`
+	var rows []webhookDelivery
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		q := tx.Where(due, now.UTC())
+		if limit > 0 {
+			q = tx.Where(`id IN (SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY endpoint_id ORDER BY next_attempt_at, id) AS n
+				FROM webhook_deliveries WHERE `+due+`) d WHERE n <= ?)`, now.UTC(), limit)
+		}
+		return q.Order("next_attempt_at, id").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Storage error while getting due webhook deliveries")
+	}
+	deliveries := make([]webhooks.Delivery, 0, len(rows))
+	for _, row := range rows {
+		deliveries = append(deliveries, row.toDelivery())
+	}
+	return deliveries, nil
+}
+
+// MarkDelivered keeps delivery from being sent again
+func (s *webhooksStorage) MarkDelivered(ctx context.Context, id int64, at time.Time) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Model(&webhookDelivery{ID: id}).Update("delivered_at", at.UTC()).Error
+	})
+	return errors.Wrap(err, "Storage error while marking webhook delivered")
+}
+
+// RetryDelivery counts failed attempt and schedules the next one
+func (s *webhooksStorage) RetryDelivery(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Model(&webhookDelivery{ID: id}).Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": next.UTC(),
+			"last_error":      lastErr,
+		}).Error
+	})
+	return errors.Wrap(err, "Storage error while scheduling webhook retry")
+}
+
+// BuryDelivery counts failed attempt and moves delivery to dead letters
+func (s *webhooksStorage) BuryDelivery(ctx context.Context, id int64, at time.Time, lastErr string) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Exec(`WITH moved AS (DELETE FROM webhook_deliveries WHERE id = ? RETURNING *)
+			INSERT INTO webhook_dead_letters (id, endpoint_id, key, topic, payload, created_at, attempts, last_error, failed_at)
+			SELECT id, endpoint_id, key, topic, payload, created_at, attempts + 1, ?, ? FROM moved`,
+			id, lastErr, at.UTC()).Error
+	})
+	return errors.Wrap(err, "Storage error while moving webhook to dead letters")
+}
+
+// EndpointFailed counts failed attempt to the endpoint and disables it after disableAfter ones in a row,
+// it reports whether the endpoint got disabled
+func (s *webhooksStorage) EndpointFailed(ctx context.Context, id int, at time.Time, disableAfter int) (bool, error) {
+	disabled := false
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		disabled = false
+		var row webhookEndpoint
+		err := tx.Raw("UPDATE webhook_endpoints SET failures = failures + 1 WHERE id = ? RETURNING *", id).Scan(&row).Error
+		if gorm.IsRecordNotFoundError(err) {
+			// endpoint deleted meanwhile
+			return nil
+		}
+		if err != nil || disableAfter <= 0 || row.Failures < disableAfter || row.DisabledAt != nil {
+			return err
+		}
+		disabled = true
+		return tx.Model(&webhookEndpoint{ID: id}).Update("disabled_at", at.UTC()).Error
+	})
+	return disabled, errors.Wrap(err, "Storage error while counting webhook endpoint failure")
+}
+
+// EndpointSucceeded resets failures of the endpoint
+func (s *webhooksStorage) EndpointSucceeded(ctx context.Context, id int) error {
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE webhook_endpoints SET failures = 0 WHERE id = ? AND failures > 0", id).Error
+	})
+	return errors.Wrap(err, "Storage error while resetting webhook endpoint failures")
+}
+
+// EnableEndpoint resets failures of the endpoint and lets its deliveries go again
+func (s *webhooksStorage) EnableEndpoint(ctx context.Context, id int) error {
+	var updated int64
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		res := tx.Exec("UPDATE webhook_endpoints SET failures = 0, disabled_at = NULL WHERE id = ?", id)
+		updated = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return errors.Wrap(err, "Storage error while enabling webhook endpoint")
+	}
+	if updated == 0 {
+		return errors.WithStack(apperrors.NewNotFound(errEndpointNotFound))
+	}
+	return nil
+}
+
+// DeadLetters returns page of dead letters of the endpoint, or of all endpoints if endpointID is zero,
+// the latest failed first, and their total number
+func (s *webhooksStorage) DeadLetters(ctx context.Context, endpointID, limit, offset int) ([]webhooks.DeadLetter, int, error) {
+	var (
+		total int
+		rows  []webhookDeadLetter
+	)
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		q := tx.Model(&webhookDeadLetter{})
+		if endpointID > 0 {
+			q = q.Where("endpoint_id = ?", endpointID)
+		}
+		if err := q.Count(&total).Error; err != nil {
+			return errors.Wrap(err, "Storage error while counting webhook dead letters")
+		}
+		q = q.Order("failed_at DESC, id DESC").Offset(offset)
+		if limit > 0 {
+			q = q.Limit(limit)
+		}
+		return errors.Wrap(q.Find(&rows).Error, "Storage error while listing webhook dead letters")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	letters := make([]webhooks.DeadLetter, 0, len(rows))
+	for _, row := range rows {
+		letters = append(letters, webhooks.DeadLetter{
+			Delivery: webhooks.Delivery{
+				ID:         row.ID,
+				EndpointID: row.EndpointID,
+				Key:        row.Key,
+				Topic:      row.Topic,
+				Payload:    []byte(row.Payload),
+				CreatedAt:  row.CreatedAt,
+				Attempts:   row.Attempts,
+				LastError:  row.LastError,
+			},
+			FailedAt: row.FailedAt,
+		})
+	}
+	return letters, total, nil
+}
+
+// ReplayDeadLetters moves dead letters back to deliveries due at the time with attempts reset.
+// Empty ids replays all dead letters of the endpoint, or of all endpoints if endpointID is zero.
+func (s *webhooksStorage) ReplayDeadLetters(ctx context.Context, endpointID int, ids []int64, at time.Time) (int, error) {
+	cond, args := "TRUE", []interface{}{}
+	if endpointID > 0 {
+		cond, args = cond+" AND endpoint_id = ?", append(args, endpointID)
+	}
+	if len(ids) > 0 {
+		cond, args = cond+" AND id IN (?)", append(args, ids)
+	}
+	// the same message queued again meanwhile is delivered once
+	var replayed int64
+	err := withTransaction(ctx, s.db, defaultTx, func(tx *gorm.DB) error {
+		res := tx.Exec(`WITH moved AS (DELETE FROM webhook_dead_letters WHERE `+cond+` RETURNING *)
+			INSERT INTO webhook_deliveries (id, endpoint_id, key, topic, payload, created_at, attempts, last_error, next_attempt_at)
+			SELECT id, endpoint_id, key, topic, payload, created_at, 0, last_error, ? FROM moved
+			ON CONFLICT (endpoint_id, key) DO NOTHING`, append(args, at.UTC())...)
+		replayed = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Storage error while replaying webhook dead letters")
+	}
+	return int(replayed), nil
+}
+
+func newWebhookEndpoint(ep webhooks.Endpoint) (webhookEndpoint, error) {
+	// filters are stored as arrays, never null
+	if ep.States == nil {
+		ep.States = []models.EventState{}
+	}
+	if ep.Statuses == nil {
+		ep.Statuses = []models.EventStatus{}
+	}
+	if ep.SourceTypes == nil {
+		ep.SourceTypes = []string{}
+	}
+	states, err := json.Marshal(ep.States)
+	if err != nil {
+		return webhookEndpoint{}, err
+	}
+	statuses, err := json.Marshal(ep.Statuses)
+	if err != nil {
+		return webhookEndpoint{}, err
+	}
+	sourceTypes, err := json.Marshal(ep.SourceTypes)
+	if err != nil {
+		return webhookEndpoint{}, err
+	}
+	createdAt := ep.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	return webhookEndpoint{
+		URL:         ep.URL,
+		Secret:      ep.Secret,
+		States:      string(states),
+		Statuses:    string(statuses),
+		SourceTypes: string(sourceTypes),
+		CreatedAt:   createdAt.UTC(),
+	}, nil
+}
+
+func (row webhookEndpoint) toEndpoint() (webhooks.Endpoint, error) {
+	ep := webhooks.Endpoint{
+		ID:         row.ID,
+		URL:        row.URL,
+		Secret:     row.Secret,
+		CreatedAt:  row.CreatedAt,
+		Failures:   row.Failures,
+		DisabledAt: row.DisabledAt,
+	}
+	if err := json.Unmarshal([]byte(row.States), &ep.States); err != nil {
+		return ep, errors.WithStack(err)
+	}
+	if err := json.Unmarshal([]byte(row.Statuses), &ep.Statuses); err != nil {
+		return ep, errors.WithStack(err)
+	}
+	if err := json.Unmarshal([]byte(row.SourceTypes), &ep.SourceTypes); err != nil {
+		return ep, errors.WithStack(err)
+	}
+	return ep, nil
+}
+
+func (row webhookDelivery) toDelivery() webhooks.Delivery {
+	return webhooks.Delivery{
+		ID:            row.ID,
+		EndpointID:    row.EndpointID,
+		Key:           row.Key,
+		Topic:         row.Topic,
+		Payload:       []byte(row.Payload),
+		CreatedAt:     row.CreatedAt,
+		Attempts:      row.Attempts,
+		NextAttemptAt: row.NextAttemptAt,
+		LastError:     row.LastError,
+	}
+}
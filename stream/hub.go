@@ -0,0 +1,221 @@
+// Package stream pushes outbox messages to live subscribers like dashboards.
+// Every app replica keeps a hub fed with all relayed messages and a bounded buffer
+// of the latest ones, so reconnecting subscribers resume where they stopped.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// Channel is Postgres channel replicas share relayed messages on
+const Channel = "stream"
+
+// Names of streamed messages by outbox topic
+var eventNames = map[string]string{
+	outbox.TopicEventCreated:   "event-created",
+	outbox.TopicEventCanceled:  "event-cancelled",
+	outbox.TopicBalanceChanged: "balance-changed",
+}
+
+// ResetEvent tells the subscriber that some messages after its Last-Event-ID are lost
+// and its state has to be reloaded
+const ResetEvent = "reset"
+
+// EventName returns name of the streamed message, empty for topics not streamed
+func EventName(m outbox.Message) string {
+	return eventNames[m.Topic]
+}
+
+// Filter selects messages of a subscriber, zero values match any message
+type Filter struct {
+	AccountID int
+	// SourceType selects event messages of the source, balance changes have none and never match
+	SourceType string
+}
+
+// subscriberSize is number of messages waiting for a subscriber before it's dropped as too slow
+const subscriberSize = 256
+
+// recentSize is the least number of the latest message IDs remembered to skip messages published again
+const recentSize = 4096
+
+type entry struct {
+	m          outbox.Message
+	accountID  int
+	sourceType string
+}
+
+func (f Filter) match(e entry) bool {
+	return (f.AccountID == 0 || f.AccountID == e.accountID) &&
+		(f.SourceType == "" || f.SourceType == e.sourceType)
+}
+
+// Subscription receives messages matching its filter in order
+type Subscription struct {
+	f Filter
+	// after is ID of the last message the subscriber has, it may come from a replica ahead of this one
+	after int64
+	c     chan outbox.Message
+}
+
+// C is closed when the hub closes or the subscriber falls behind,
+// the subscriber should then reconnect and resume from the last received message
+func (s *Subscription) C() <-chan outbox.Message {
+	return s.c
+}
+
+type hub struct {
+	mu   sync.Mutex
+	size int
+	// buffer keeps the latest messages in order
+	buffer []entry
+	// since is ID of the latest message not in buffer, messages after it are all known
+	since int64
+	// started is false until the first message arrives
+	started bool
+	// recent are IDs of the latest messages, recentOrder keeps them in order they came to forget the oldest
+	recent      map[int64]struct{}
+	recentOrder []int64
+	subs        map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewHub returns hub keeping the latest size messages for subscribers to resume from
+func NewHub(size int) *hub {
+	return &hub{
+		size:   size,
+		recent: make(map[int64]struct{}),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish passes the message to matching subscribers.
+// Messages of other topics and the recent ones seen already are skipped, so outbox may publish them again.
+// Outbox IDs grow in commit order, so resuming subscribers get messages after their last one by ID.
+func (h *hub) Publish(_ context.Context, m outbox.Message) error {
+	if EventName(m) == "" {
+		return nil
+	}
+	var p struct {
+		AccountID  int    `json:"accountId"`
+		SourceType string `json:"sourceType"`
+	}
+	// payloads are written by outbox, a broken one only matches the empty filter
+	json.Unmarshal(m.Payload, &p)
+	e := entry{m: m, accountID: p.AccountID, sourceType: p.SourceType}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || !h.remember(m.ID) {
+		return nil
+	}
+	if !h.started {
+		// messages relayed before the hub started are unknown
+		h.since, h.started = m.ID-1, true
+	}
+	if len(h.buffer) >= h.size {
+		if len(h.buffer) > 0 {
+			h.since = h.buffer[0].m.ID
+			h.buffer = append(h.buffer[:0], h.buffer[1:]...)
+		} else {
+			h.since = m.ID
+		}
+	}
+	if h.size > 0 {
+		h.buffer = append(h.buffer, e)
+	}
+	for s := range h.subs {
+		if !s.f.match(e) || m.ID <= s.after {
+			continue
+		}
+		select {
+		case s.c <- m:
+		default:
+			// dropped subscriber resumes from the buffer
+			delete(h.subs, s)
+			close(s.c)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns subscription to messages after lastID, zero means only new messages.
+// Buffered messages after lastID come first. Resumed is false if some messages after lastID
+// are no longer buffered or were never seen by the hub, the subscriber should reload its state then.
+func (h *hub) Subscribe(f Filter, lastID int64) (sub *Subscription, resumed bool) {
+	sub = &Subscription{f: f, after: lastID, c: make(chan outbox.Message, subscriberSize+h.size)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.c)
+		return sub, false
+	}
+	resumed = true
+	if lastID > 0 {
+		resumed = h.started && lastID >= h.since
+		for _, e := range h.buffer {
+			if e.m.ID > lastID && f.match(e) {
+				sub.c <- e.m
+			}
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub, resumed
+}
+
+// Unsubscribe stops sending messages to the subscription
+func (h *hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Reset forgets buffered messages and ends all subscriptions when some messages were missed,
+// so subscribers reconnect and learn they have to reload their state
+func (h *hub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buffer, h.since, h.started = nil, 0, false
+	h.recent, h.recentOrder = make(map[int64]struct{}), nil
+	h.closeSubscriptions()
+}
+
+// Close ends all subscriptions and refuses new ones, like on shutdown
+func (h *hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	h.closeSubscriptions()
+}
+
+func (h *hub) closeSubscriptions() {
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// remember adds message ID to the recent ones, false means it's there already
+func (h *hub) remember(id int64) bool {
+	if _, ok := h.recent[id]; ok {
+		return false
+	}
+	limit := recentSize
+	if h.size > limit {
+		limit = h.size
+	}
+	if len(h.recentOrder) >= limit {
+		delete(h.recent, h.recentOrder[0])
+		h.recentOrder = append(h.recentOrder[:0], h.recentOrder[1:]...)
+	}
+	h.recent[id] = struct{}{}
+	h.recentOrder = append(h.recentOrder, id)
+	return true
+}
@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+func eventMessage(id int64, accountID int, sourceType string) outbox.Message {
+	return outbox.Message{
+		ID:      id,
+		Topic:   outbox.TopicEventCreated,
+		Payload: []byte(fmt.Sprintf(`{"accountId":%d,"sourceType":%q}`, accountID, sourceType)),
+	}
+}
+
+func balanceMessage(id int64, accountID int) outbox.Message {
+	return outbox.Message{
+		ID:      id,
+		Topic:   outbox.TopicBalanceChanged,
+		Payload: []byte(fmt.Sprintf(`{"accountId":%d}`, accountID)),
+	}
+}
+
+// received returns IDs of messages waiting in subscription
+func received(sub *Subscription) []int64 {
+	var ids []int64
+	for {
+		select {
+		case m, ok := <-sub.C():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, m.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestHubFilters(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h := NewHub(10)
+	all, _ := h.Subscribe(Filter{}, 0)
+	account, _ := h.Subscribe(Filter{AccountID: 1}, 0)
+	game, _ := h.Subscribe(Filter{SourceType: "game"}, 0)
+
+	a.NoError(h.Publish(ctx, eventMessage(1, 1, "game")))
+	a.NoError(h.Publish(ctx, balanceMessage(2, 1)))
+	a.NoError(h.Publish(ctx, eventMessage(3, 2, "game")))
+	a.NoError(h.Publish(ctx, eventMessage(4, 1, "server")))
+	// repeated messages are skipped
+	a.NoError(h.Publish(ctx, eventMessage(3, 2, "game")))
+
+	a.Equal([]int64{1, 2, 3, 4}, received(all))
+	a.Equal([]int64{1, 2, 4}, received(account))
+	a.Equal([]int64{1, 3}, received(game))
+}
+
+func TestHubResume(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h := NewHub(3)
+
+	// nothing is known before the first message
+	_, resumed := h.Subscribe(Filter{}, 5)
+	a.False(resumed)
+
+	for id := int64(10); id <= 14; id++ {
+		a.NoError(h.Publish(ctx, eventMessage(id, 1, "game")))
+	}
+	// buffer holds 12..14
+	sub, resumed := h.Subscribe(Filter{}, 11)
+	a.True(resumed)
+	a.Equal([]int64{12, 13, 14}, received(sub))
+
+	sub, resumed = h.Subscribe(Filter{}, 13)
+	a.True(resumed)
+	a.Equal([]int64{14}, received(sub))
+	a.NoError(h.Publish(ctx, eventMessage(15, 1, "game")))
+	a.Equal([]int64{15}, received(sub))
+
+	// 11 is evicted
+	sub, resumed = h.Subscribe(Filter{}, 10)
+	a.False(resumed)
+	a.Equal([]int64{13, 14, 15}, received(sub))
+
+	// subscriber ahead of the hub gets only newer messages
+	sub, resumed = h.Subscribe(Filter{}, 16)
+	a.True(resumed)
+	a.NoError(h.Publish(ctx, eventMessage(16, 1, "game")))
+	a.NoError(h.Publish(ctx, eventMessage(17, 1, "game")))
+	a.Equal([]int64{17}, received(sub))
+}
+
+// Messages published again are skipped, but a message with lower ID than seen ones is still new
+func TestHubSkipsRepeatedMessages(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h := NewHub(10)
+	sub, _ := h.Subscribe(Filter{}, 0)
+	for _, id := range []int64{1, 3, 2, 3, 1} {
+		a.NoError(h.Publish(ctx, eventMessage(id, 1, "game")))
+	}
+	a.Equal([]int64{1, 3, 2}, received(sub))
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h := NewHub(0)
+	slow, _ := h.Subscribe(Filter{}, 0)
+	for id := int64(1); id <= subscriberSize+1; id++ {
+		a.NoError(h.Publish(ctx, eventMessage(id, 1, "game")))
+	}
+	ids := received(slow)
+	a.Len(ids, subscriberSize)
+	_, ok := <-slow.C()
+	a.False(ok)
+}
+
+func TestHubResetAndClose(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h := NewHub(10)
+	a.NoError(h.Publish(ctx, eventMessage(1, 1, "game")))
+	sub, _ := h.Subscribe(Filter{}, 0)
+
+	h.Reset()
+	_, ok := <-sub.C()
+	a.False(ok)
+	_, resumed := h.Subscribe(Filter{}, 1)
+	a.False(resumed)
+
+	a.NoError(h.Publish(ctx, eventMessage(2, 1, "game")))
+	sub, resumed = h.Subscribe(Filter{}, 1)
+	a.True(resumed)
+	a.Equal([]int64{2}, received(sub))
+
+	h.Close()
+	a.Empty(received(sub))
+	sub, resumed = h.Subscribe(Filter{}, 0)
+	a.False(resumed)
+	_, ok = <-sub.C()
+	a.False(ok)
+	// unsubscribing closed subscription is safe
+	h.Unsubscribe(sub)
+}
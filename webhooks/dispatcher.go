@@ -0,0 +1,239 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// LockName identifies lock of the process dispatching deliveries
+const LockName = "webhooks"
+
+// RetryPolicy spaces attempts of failing delivery
+type RetryPolicy struct {
+	// MaxAttempts failed in a row move delivery to dead letters
+	MaxAttempts int
+	// Backoff is delay after the first failure, doubled after every next one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DisableAfter attempts to the endpoint failed in a row disable it, zero never does
+	DisableAfter int
+}
+
+// Delay returns delay before the next attempt after the given number of failed ones
+func (p RetryPolicy) Delay(failed int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < failed && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+type deliveryStore interface {
+	Endpoints(context.Context) ([]Endpoint, error)
+	// DueDeliveries returns undelivered deliveries to enabled endpoints due at the time, the earliest first,
+	// limit applies to every endpoint
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+	MarkDelivered(ctx context.Context, id int64, at time.Time) error
+	// RetryDelivery counts failed attempt and schedules the next one
+	RetryDelivery(ctx context.Context, id int64, next time.Time, lastErr string) error
+	// BuryDelivery moves delivery to dead letters
+	BuryDelivery(ctx context.Context, id int64, at time.Time, lastErr string) error
+	// EndpointFailed counts failed attempt to the endpoint and disables it after disableAfter ones in a row,
+	// it reports whether the endpoint got disabled
+	EndpointFailed(ctx context.Context, id int, at time.Time, disableAfter int) (bool, error)
+	// EndpointSucceeded resets failures of the endpoint
+	EndpointSucceeded(ctx context.Context, id int) error
+}
+
+// locker elects the only process dispatching deliveries, so none is sent twice at once
+type locker interface {
+	TryLock(context.Context) (bool, error)
+	Unlock(context.Context) error
+}
+
+type dispatcher struct {
+	st        deliveryStore
+	client    *http.Client
+	lock      locker
+	policy    RetryPolicy
+	batchSize int
+}
+
+// NewDispatcher returns dispatcher posting due deliveries by batches of batchSize to every endpoint
+func NewDispatcher(st deliveryStore, client *http.Client, lock locker, policy RetryPolicy, batchSize int) *dispatcher {
+	return &dispatcher{
+		st:        st,
+		client:    client,
+		lock:      lock,
+		policy:    policy,
+		batchSize: batchSize,
+	}
+}
+
+// notification is JSON body posted to endpoints
+type notification struct {
+	Key       string          `json:"key"`
+	Topic     string          `json:"topic"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Dispatch posts due deliveries and returns number of successful ones.
+// Failed delivery is retried after backoff delay until it runs out of attempts.
+// Endpoint failing too many attempts in a row is disabled, its deliveries wait till it's enabled.
+func (d *dispatcher) Dispatch(ctx context.Context) (int, error) {
+	leader, err := d.lock.TryLock(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Webhook dispatcher can't take lock")
+	}
+	if !leader {
+		log.Print("Webhooks are dispatched by another process, skipping")
+		return 0, nil
+	}
+	endpoints, err := d.st.Endpoints(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Webhook dispatcher can't get endpoints")
+	}
+	byID := make(map[int]Endpoint, len(endpoints))
+	for _, ep := range endpoints {
+		byID[ep.ID] = ep
+	}
+	deliveries, err := d.st.DueDeliveries(ctx, time.Now().UTC(), d.batchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "Webhook dispatcher can't get due deliveries")
+	}
+	byEndpoint := make(map[int][]Delivery)
+	for _, dl := range deliveries {
+		// endpoint may be deleted after the deliveries were read
+		if _, ok := byID[dl.EndpointID]; ok {
+			byEndpoint[dl.EndpointID] = append(byEndpoint[dl.EndpointID], dl)
+		}
+	}
+	// every endpoint gets its own worker, so a slow one doesn't hold up the others
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+		firstErr  error
+	)
+	for id, list := range byEndpoint {
+		wg.Add(1)
+		go func(ep Endpoint, list []Delivery) {
+			defer wg.Done()
+			n, err := d.dispatchEndpoint(ctx, ep, list)
+			mu.Lock()
+			defer mu.Unlock()
+			delivered += n
+			if firstErr == nil {
+				firstErr = err
+			}
+		}(byID[id], list)
+	}
+	wg.Wait()
+	return delivered, firstErr
+}
+
+// dispatchEndpoint posts deliveries to the endpoint one by one and returns number of successful ones
+func (d *dispatcher) dispatchEndpoint(ctx context.Context, ep Endpoint, deliveries []Delivery) (int, error) {
+	delivered := 0
+	for _, dl := range deliveries {
+		if err := ctx.Err(); err != nil {
+			return delivered, errors.WithStack(err)
+		}
+		if ep.DisabledAt != nil {
+			// disabled by this run
+			return delivered, nil
+		}
+		sendErr := d.send(ctx, ep, dl)
+		now := time.Now().UTC()
+		var err error
+		switch {
+		case sendErr == nil:
+			err = d.st.MarkDelivered(ctx, dl.ID, now)
+			delivered++
+		case dl.Attempts+1 >= d.policy.MaxAttempts:
+			log.Printf("Webhook delivery %d to endpoint %d is dead: %s", dl.ID, ep.ID, sendErr)
+			err = d.st.BuryDelivery(ctx, dl.ID, now, sendErr.Error())
+		default:
+			err = d.st.RetryDelivery(ctx, dl.ID, now.Add(d.policy.Delay(dl.Attempts+1)), sendErr.Error())
+		}
+		if err != nil {
+			return delivered, errors.Wrapf(err, "Webhook dispatcher can't update delivery %d", dl.ID)
+		}
+		if err := d.countAttempt(ctx, &ep, sendErr, now); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// countAttempt keeps failures of the endpoint in a row and disables it after too many
+func (d *dispatcher) countAttempt(ctx context.Context, ep *Endpoint, sendErr error, now time.Time) error {
+	if sendErr == nil {
+		if ep.Failures == 0 {
+			return nil
+		}
+		ep.Failures = 0
+		return errors.Wrapf(d.st.EndpointSucceeded(ctx, ep.ID), "Webhook dispatcher can't reset failures of endpoint %d", ep.ID)
+	}
+	ep.Failures++
+	disabled, err := d.st.EndpointFailed(ctx, ep.ID, now, d.policy.DisableAfter)
+	if err != nil {
+		return errors.Wrapf(err, "Webhook dispatcher can't count failure of endpoint %d", ep.ID)
+	}
+	if disabled {
+		log.Printf("Webhook endpoint %d is disabled after %d failures in a row: %s", ep.ID, ep.Failures, sendErr)
+		ep.DisabledAt = &now
+	}
+	return nil
+}
+
+// Release gives up dispatcher lock, so another process takes over without waiting
+func (d *dispatcher) Release(ctx context.Context) error {
+	return errors.Wrap(d.lock.Unlock(ctx), "Webhook dispatcher can't release lock")
+}
+
+func (d *dispatcher) send(ctx context.Context, ep Endpoint, dl Delivery) error {
+	body, err := json.Marshal(notification{
+		Key:       dl.Key,
+		Topic:     dl.Topic,
+		Payload:   dl.Payload,
+		CreatedAt: dl.CreatedAt,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(outbox.KeyHeader, dl.Key)
+	req.Header.Set(SignatureHeader, Sign(ep.Secret, time.Now(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	// drain body so the connection is reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// testStore keeps deliveries like storage does
+type testStore struct {
+	mu         sync.Mutex
+	endpoints  []Endpoint
+	deliveries []Delivery
+	delivered  map[int64]bool
+	dead       []DeadLetter
+}
+
+func (s *testStore) Endpoints(context.Context) ([]Endpoint, error) {
+	return s.endpoints, nil
+}
+
+func (s *testStore) EnqueueDeliveries(_ context.Context, deliveries []Delivery) error {
+	for _, d := range deliveries {
+		d.ID = int64(len(s.deliveries) + 1)
+		s.deliveries = append(s.deliveries, d)
+	}
+	return nil
+}
+
+func (s *testStore) DueDeliveries(_ context.Context, now time.Time, limit int) ([]Delivery, error) {
+	var due []Delivery
+	perEndpoint := make(map[int]int)
+	for _, d := range s.deliveries {
+		if !s.delivered[d.ID] && !d.NextAttemptAt.After(now) && (limit <= 0 || perEndpoint[d.EndpointID] < limit) {
+			perEndpoint[d.EndpointID]++
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (s *testStore) MarkDelivered(_ context.Context, id int64, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[id] = true
+	return nil
+}
+
+func (s *testStore) RetryDelivery(_ context.Context, id int64, next time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.get(id)
+	d.Attempts, d.NextAttemptAt, d.LastError = d.Attempts+1, next, lastErr
+	return nil
+}
+
+func (s *testStore) BuryDelivery(_ context.Context, id int64, at time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.get(id)
+	d.Attempts, d.LastError = d.Attempts+1, lastErr
+	s.dead = append(s.dead, DeadLetter{Delivery: *d, FailedAt: at})
+	s.delivered[id] = true
+	return nil
+}
+
+func (s *testStore) EndpointFailed(_ context.Context, id int, at time.Time, disableAfter int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep := &s.endpoints[id-1]
+	ep.Failures++
+	if disableAfter <= 0 || ep.Failures < disableAfter || ep.DisabledAt != nil {
+		return false, nil
+	}
+	ep.DisabledAt = &at
+	return true, nil
+}
+
+func (s *testStore) EndpointSucceeded(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoints[id-1].Failures = 0
+	return nil
+}
+
+func (s *testStore) get(id int64) *Delivery {
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == id {
+			return &s.deliveries[i]
+		}
+	}
+	return nil
+}
+
+type testLock bool
+
+func (l testLock) TryLock(context.Context) (bool, error) { return bool(l), nil }
+func (l testLock) Unlock(context.Context) error          { return nil }
+
+func TestPublisherQueuesMatchingEndpoints(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	st := &testStore{endpoints: []Endpoint{
+		{ID: 1, States: []models.EventState{models.StateWin}},
+		{ID: 2, States: []models.EventState{models.StateLoss}},
+		{ID: 3},
+	}}
+	pub := NewPublisher(st)
+
+	win := models.Event{ID: 1, TransactionID: "t-1", State: models.StateWin, Status: models.StatusProcessed}
+	a.NoError(pub.Publish(ctx, outbox.EventCreated(win)))
+	a.NoError(pub.Publish(ctx, outbox.BalanceChanged(models.Balance{Version: 1})))
+
+	if a.Len(st.deliveries, 2) {
+		a.Equal(1, st.deliveries[0].EndpointID)
+		a.Equal(3, st.deliveries[1].EndpointID)
+		a.Equal("event.created:t-1", st.deliveries[0].Key)
+		a.Equal(outbox.TopicEventCreated, st.deliveries[0].Topic)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	const secret = "top-secret"
+
+	fail := true
+	var requests []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests, bodies = append(requests, r), append(bodies, body)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	st := &testStore{
+		endpoints: []Endpoint{{ID: 1, URL: srv.URL, Secret: secret}},
+		delivered: make(map[int64]bool),
+	}
+	now := time.Now().UTC()
+	a.NoError(st.EnqueueDeliveries(ctx, []Delivery{{
+		EndpointID:    1,
+		Key:           "event.created:t-1",
+		Topic:         outbox.TopicEventCreated,
+		Payload:       json.RawMessage(`{"id":1}`),
+		NextAttemptAt: now,
+	}}))
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Hour, MaxBackoff: 2 * time.Hour}
+	d := NewDispatcher(st, srv.Client(), testLock(true), policy, 10)
+
+	// failed attempt is retried after backoff
+	n, err := d.Dispatch(ctx)
+	a.NoError(err)
+	a.Equal(0, n)
+	dl := st.get(1)
+	a.Equal(1, dl.Attempts)
+	a.Contains(dl.LastError, "503")
+	a.WithinDuration(now.Add(time.Hour), dl.NextAttemptAt, time.Minute)
+
+	n, err = d.Dispatch(ctx)
+	a.NoError(err)
+	a.Equal(0, n)
+	a.Len(requests, 1)
+
+	// the last attempt moves delivery to dead letters
+	dl.NextAttemptAt = now
+	_, err = d.Dispatch(ctx)
+	a.NoError(err)
+	dl.NextAttemptAt = now
+	_, err = d.Dispatch(ctx)
+	a.NoError(err)
+	a.Len(requests, 3)
+	if a.Len(st.dead, 1) {
+		a.Equal(3, st.dead[0].Attempts)
+	}
+
+	// replayed delivery succeeds
+	st.delivered[1], dl.Attempts, fail = false, 0, false
+	n, err = d.Dispatch(ctx)
+	a.NoError(err)
+	a.Equal(1, n)
+	a.True(st.delivered[1])
+
+	last, body := requests[len(requests)-1], bodies[len(bodies)-1]
+	a.Equal("event.created:t-1", last.Header.Get(outbox.KeyHeader))
+	a.JSONEq(`{"key":"event.created:t-1","topic":"event.created","payload":{"id":1},"createdAt":"0001-01-01T00:00:00Z"}`,
+		string(body))
+	a.True(validSignature(last.Header.Get(SignatureHeader), secret, body))
+	a.False(validSignature(last.Header.Get(SignatureHeader), "other", body))
+}
+
+// Endpoint failing attempts in a row is disabled and isn't tried till enabled
+func TestDispatchDisablesFailingEndpoint(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	st := &testStore{
+		endpoints: []Endpoint{{ID: 1, URL: srv.URL, Secret: "s"}},
+		delivered: make(map[int64]bool),
+	}
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		a.NoError(st.EnqueueDeliveries(ctx, []Delivery{{EndpointID: 1, Key: strconv.Itoa(i), NextAttemptAt: now}}))
+	}
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: time.Hour, DisableAfter: 2}
+	n, err := NewDispatcher(st, srv.Client(), testLock(true), policy, 10).Dispatch(ctx)
+	a.NoError(err)
+	a.Equal(0, n)
+	a.Equal(2, requests)
+	a.Equal(2, st.endpoints[0].Failures)
+	a.NotNil(st.endpoints[0].DisabledAt)
+	// the delivery left after the endpoint got disabled wasn't attempted
+	a.Equal(0, st.get(3).Attempts)
+}
+
+// Slow endpoint doesn't hold up deliveries to the others
+func TestDispatchEndpointsInParallel(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fastGot := make(chan struct{}, 2)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastGot <- struct{}{}
+	}))
+	defer fast.Close()
+
+	st := &testStore{
+		endpoints: []Endpoint{{ID: 1, URL: slow.URL, Secret: "s"}, {ID: 2, URL: fast.URL, Secret: "s"}},
+		delivered: make(map[int64]bool),
+	}
+	now := time.Now().UTC()
+	a.NoError(st.EnqueueDeliveries(ctx, []Delivery{
+		{EndpointID: 1, Key: "1", NextAttemptAt: now},
+		{EndpointID: 2, Key: "2", NextAttemptAt: now},
+		{EndpointID: 2, Key: "3", NextAttemptAt: now},
+	}))
+	d := NewDispatcher(st, http.DefaultClient, testLock(true), RetryPolicy{MaxAttempts: 3}, 10)
+	done := make(chan int, 1)
+	go func() {
+		n, err := d.Dispatch(ctx)
+		a.NoError(err)
+		done <- n
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-fastGot:
+		case <-time.After(2 * time.Second):
+			t.Fatal("fast endpoint waits for the slow one")
+		}
+	}
+	close(release)
+	a.Equal(3, <-done)
+}
+
+func TestDispatchNotLeader(t *testing.T) {
+	a := assert.New(t)
+	st := &testStore{
+		endpoints:  []Endpoint{{ID: 1, URL: "http://127.0.0.1:1", Secret: "s"}},
+		deliveries: []Delivery{{ID: 1, EndpointID: 1}},
+		delivered:  make(map[int64]bool),
+	}
+	n, err := NewDispatcher(st, http.DefaultClient, testLock(false), RetryPolicy{}, 10).Dispatch(context.Background())
+	a.NoError(err)
+	a.Equal(0, n)
+	a.Equal(0, st.deliveries[0].Attempts)
+}
+
+// validSignature verifies signature header the way receivers do
+func validSignature(header, secret string, body []byte) bool {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") {
+		return false
+	}
+	unix, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "t="), 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(header), []byte(Sign(secret, time.Unix(unix, 0), body)))
+}
@@ -0,0 +1,95 @@
+// Package webhooks notifies registered endpoints about created and canceled events.
+// Deliveries are queued from outbox messages and posted until they succeed,
+// those failing every attempt are moved to dead letters to be replayed later.
+package webhooks
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+// Endpoint receives notifications about events matching its filters, empty filter matches any event
+type Endpoint struct {
+	ID  int
+	URL string
+	// Secret signs every notification
+	Secret      string
+	States      []models.EventState
+	Statuses    []models.EventStatus
+	SourceTypes []string
+	CreatedAt   time.Time
+	// Failures counts attempts failed in a row
+	Failures int
+	// DisabledAt is set once the endpoint failed RetryPolicy.DisableAfter attempts in a row,
+	// its deliveries wait till it's enabled again
+	DisabledAt *time.Time
+}
+
+// Validate checks endpoint URL and filters
+func (e Endpoint) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("URL must be absolute http or https URL")
+	}
+	if e.Secret == "" {
+		return errors.New("Secret is required")
+	}
+	for _, s := range e.States {
+		if s != models.StateWin && s != models.StateLoss {
+			return errors.Errorf("State %q is not valid", s)
+		}
+	}
+	for _, s := range e.Statuses {
+		if s != models.StatusProcessed && s != models.StatusCanceled {
+			return errors.Errorf("Status %q is not valid", s)
+		}
+	}
+	return nil
+}
+
+// Match reports whether event in the message payload passes endpoint filters
+func (e Endpoint) Match(p outbox.EventPayload) bool {
+	return matchAny(len(e.States), func(i int) bool { return strings.EqualFold(string(e.States[i]), p.State) }) &&
+		matchAny(len(e.Statuses), func(i int) bool { return strings.EqualFold(string(e.Statuses[i]), p.Status) }) &&
+		matchAny(len(e.SourceTypes), func(i int) bool { return e.SourceTypes[i] == p.SourceType })
+}
+
+func matchAny(n int, match func(i int) bool) bool {
+	if n == 0 {
+		return true
+	}
+	for i := 0; i < n; i++ {
+		if match(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is notification queued for the endpoint
+type Delivery struct {
+	ID         int64
+	EndpointID int
+	// Key, Topic and Payload are those of outbox message, the key is the same on every attempt
+	Key       string
+	Topic     string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+	// Attempts counts failed attempts
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// DeadLetter is delivery which failed every attempt, it keeps ID of the delivery
+type DeadLetter struct {
+	Delivery
+	FailedAt time.Time
+}
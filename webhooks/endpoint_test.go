@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/djumpen/test-ex-go/models"
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+func TestEndpointMatch(t *testing.T) {
+	a := assert.New(t)
+	win := outbox.EventPayload{State: "win", Status: "processed", SourceType: "game"}
+	canceled := outbox.EventPayload{State: "loss", Status: "canceled", SourceType: "server"}
+
+	any := Endpoint{}
+	a.True(any.Match(win))
+	a.True(any.Match(canceled))
+
+	wins := Endpoint{States: []models.EventState{models.StateWin}}
+	a.True(wins.Match(win))
+	a.False(wins.Match(canceled))
+
+	filtered := Endpoint{
+		Statuses:    []models.EventStatus{models.StatusCanceled},
+		SourceTypes: []string{"game", "server"},
+	}
+	a.False(filtered.Match(win))
+	a.True(filtered.Match(canceled))
+}
+
+func TestEndpointValidate(t *testing.T) {
+	a := assert.New(t)
+	ep := Endpoint{URL: "https://example.com/hook", Secret: "s"}
+	a.NoError(ep.Validate())
+
+	for _, u := range []string{"", "example.com/hook", "ftp://example.com", "http://"} {
+		invalid := ep
+		invalid.URL = u
+		a.Error(invalid.Validate(), u)
+	}
+	invalid := ep
+	invalid.Secret = ""
+	a.Error(invalid.Validate())
+	invalid = ep
+	invalid.States = []models.EventState{"win"}
+	a.Error(invalid.Validate())
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	a := assert.New(t)
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+	a.Equal(time.Second, p.Delay(1))
+	a.Equal(2*time.Second, p.Delay(2))
+	a.Equal(8*time.Second, p.Delay(4))
+	a.Equal(10*time.Second, p.Delay(5))
+	a.Equal(10*time.Second, p.Delay(100))
+}
@@ -0,0 +1,61 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djumpen/test-ex-go/outbox"
+)
+
+type queue interface {
+	Endpoints(context.Context) ([]Endpoint, error)
+	// EnqueueDeliveries skips deliveries already queued for the endpoint with the same key
+	EnqueueDeliveries(context.Context, []Delivery) error
+}
+
+type publisher struct {
+	st queue
+}
+
+// NewPublisher returns outbox publisher queueing deliveries of event messages to matching endpoints
+func NewPublisher(st queue) *publisher {
+	return &publisher{
+		st: st,
+	}
+}
+
+func (p *publisher) Publish(ctx context.Context, m outbox.Message) error {
+	if m.Topic != outbox.TopicEventCreated && m.Topic != outbox.TopicEventCanceled {
+		return nil
+	}
+	var e outbox.EventPayload
+	if err := json.Unmarshal(m.Payload, &e); err != nil {
+		return errors.Wrapf(err, "Can't decode message %s", m.Key)
+	}
+	endpoints, err := p.st.Endpoints(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Can't get webhook endpoints")
+	}
+	now := time.Now().UTC()
+	var deliveries []Delivery
+	for _, ep := range endpoints {
+		if !ep.Match(e) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			EndpointID:    ep.ID,
+			Key:           m.Key,
+			Topic:         m.Topic,
+			Payload:       m.Payload,
+			CreatedAt:     m.CreatedAt,
+			NextAttemptAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return errors.Wrap(p.st.EnqueueDeliveries(ctx, deliveries), "Can't queue webhook deliveries")
+}
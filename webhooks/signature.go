@@ -0,0 +1,24 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SignatureHeader carries signature of notification like "t=1600000000,v1=5257a8...",
+// where v1 is hex HMAC-SHA256 of "<t>.<body>" with the endpoint secret
+const SignatureHeader = "Webhook-Signature"
+
+// Sign returns signature header value of the body sent at the time
+func Sign(secret string, at time.Time, body []byte) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}